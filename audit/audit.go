@@ -0,0 +1,246 @@
+// An append-only log of the commands that the issuebot ran and the
+// changes they made on github.  The log is kept as a file of JSON lines
+// (one Entry per line) so it can be exported and processed with ordinary
+// tools.  The most recent entries are also kept in memory for queries.
+//
+// Typical use:
+//
+//	l, err := audit.Open("/var/lib/issuebot/audit.jsonl")
+//	err = l.Append(&audit.Entry{Time: time.Now(), User: "U123", Text: "close 12"})
+//	entries := l.Query(&audit.Query{Issue: 12})
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.WithFields(logrus.Fields{"component": "audit"})
+
+// Number of recent entries kept in memory for Query()
+var MaxRecent = 10000
+
+// Longest line read back from a log file
+const maxLine = 1 << 20
+
+// Entry records one command and the github changes it made.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Team      string    `json:"team,omitempty"`
+	User      string    `json:"user,omitempty"`      // slack user ID
+	UserName  string    `json:"user_name,omitempty"` // slack user name
+	Channel   string    `json:"channel,omitempty"`
+	Source    string    `json:"source"` // e.g. "command" or "button"
+	Text      string    `json:"text"`   // what the user ran
+	Repo      string    `json:"repo,omitempty"`
+	Calls     []*Call   `json:"calls,omitempty"`
+	Result    string    `json:"result,omitempty"` // the reply to the user
+	LatencyMS int64     `json:"latency_ms"`
+}
+
+// Call records one request that changed something on github.
+type Call struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Issue  int             `json:"issue,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Query selects log entries.  Zero fields match every entry.
+type Query struct {
+	User  string // slack user ID or name
+	Issue int    // an issue that one of the entry's calls changed
+	Since time.Time
+	Limit int // return at most this many of the newest matches
+}
+
+// Returns true if the entry matches the query.
+func (q *Query) Match(e *Entry) bool {
+	if q.User != "" && q.User != e.User && q.User != e.UserName {
+		return false
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if q.Issue == 0 {
+		return true
+	}
+	for _, c := range e.Calls {
+		if c.Issue == q.Issue {
+			return true
+		}
+	}
+	return false
+}
+
+// Log is an audit log.  Entries are only ever added.
+type Log struct {
+	sync.Mutex
+	path   string
+	f      *os.File
+	recent []*Entry
+}
+
+// Open the audit log kept in the file at path creating it if needed.  If
+// path is empty the log is only kept in memory.
+func Open(path string) (*Log, error) {
+	l := &Log{path: path}
+	if path == "" {
+		return l, nil
+	}
+	err := l.scan(func(e *Entry) error {
+		l.remember(e)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// the log may hold secrets that users typed so only the owner may
+	// read it
+	l.f, err = os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = l.endLine(); err != nil {
+		l.f.Close()
+		return nil, err
+	}
+	log.Infof("loaded %d audit entries from %s", len(l.recent), path)
+	return l, nil
+}
+
+// Add an entry to the log.  Each entry is written with a single write so
+// that an entry is never split by a crash or by another writer.
+func (l *Log) Append(e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.remember(e)
+	if l.f == nil {
+		return nil
+	}
+	_, err = l.f.Write(append(data, '\n'))
+	return err
+}
+
+// Return the recent entries that match q, oldest first.
+func (l *Log) Query(q *Query) []*Entry {
+	l.Lock()
+	defer l.Unlock()
+	var out []*Entry
+	for i := len(l.recent) - 1; i >= 0; i-- {
+		if q.Limit > 0 && len(out) == q.Limit {
+			break
+		}
+		if q.Match(l.recent[i]) {
+			out = append(out, l.recent[i])
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// Write every entry that matches q to w as JSON lines, oldest first.
+// Entries come from the log file so older entries than Query() knows
+// about are included.  q.Limit is ignored.
+func (l *Log) Export(w io.Writer, q *Query) error {
+	enc := json.NewEncoder(w)
+	if l.path == "" {
+		l.Lock()
+		entries := append([]*Entry(nil), l.recent...)
+		l.Unlock()
+		for _, e := range entries {
+			if q.Match(e) {
+				if err := enc.Encode(e); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return l.scan(func(e *Entry) error {
+		if !q.Match(e) {
+			return nil
+		}
+		return enc.Encode(e)
+	})
+}
+
+// Close the log file.
+func (l *Log) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// Keep an entry for queries.  Old entries are dropped in batches so that
+// appends don't have to copy the slice each time.  Must be called with
+// the lock held (or before the log is shared).
+func (l *Log) remember(e *Entry) {
+	l.recent = append(l.recent, e)
+	if len(l.recent) > MaxRecent+MaxRecent/8 {
+		n := len(l.recent) - MaxRecent
+		l.recent = append([]*Entry(nil), l.recent[n:]...)
+	}
+}
+
+// Finish a line that a crash cut short so that the next entry starts on
+// a line of its own.
+func (l *Log) endLine() error {
+	fi, err := l.f.Stat()
+	if err != nil || fi.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err = l.f.ReadAt(last, fi.Size()-1); err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = l.f.Write([]byte{'\n'})
+	}
+	return err
+}
+
+// Call fn for each entry in the log file.  Lines that can't be decoded
+// (e.g. one cut short by a crash) are skipped.
+func (l *Log) scan(fn func(*Entry) error) error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), maxLine)
+	for n := 1; sc.Scan(); n++ {
+		var e Entry
+		if err = json.Unmarshal(sc.Bytes(), &e); err != nil {
+			log.Warnf("%s:%d: skipping bad entry: %s", l.path, n, err)
+			continue
+		}
+		if err = fn(&e); err != nil {
+			return err
+		}
+	}
+	if err = sc.Err(); err != nil {
+		return fmt.Errorf("%s: %s", l.path, err)
+	}
+	return nil
+}
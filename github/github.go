@@ -5,10 +5,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -18,31 +20,75 @@ var _ = ioutil.ReadAll // XXX REMOVE ME when done debugging
 
 var l = logrus.WithFields(logrus.Fields{"component": "github"})
 
+// Root URL of the public github API
+const APIRoot = "https://api.github.com/"
+
 // Base URL for API access
-const APIURL = "https://api.github.com/repos/"
+const APIURL = APIRoot + "repos/"
+
+// The HTTP client used for all github requests.  Tests can replace it or
+// its Transport to redirect, record or replay github traffic.
+var Client = &http.Client{}
 
 // Issue represents the fields of an individual issue.
 type Issue struct {
-	Number    int
-	Title     string
-	ID        int
-	HTMLURL   string `json:"html_url"`
-	State     string
-	User      *User
-	Assignee  *User
-	Body      string
-	CreatedAt time.Time `json:created_at`
-	UpdatedAt time.Time `json:updated_at`
-	ClosedAt  time.Time `json:closed_at`
-	Labels    []*Label
-	Locked    bool
+	Number      int
+	Title       string
+	ID          int
+	HTMLURL     string `json:"html_url"`
+	State       string
+	User        *User
+	Assignee    *User
+	Assignees   []*User
+	Body        string
+	Comments    int
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	ClosedAt    time.Time `json:"closed_at"`
+	Labels      []*Label
+	Milestone   *Milestone
+	Locked      bool
+	PullRequest *PullRequestRef `json:"pull_request"`
+}
+
+// PullRequestRef is set on the entries of issue lists and searches that
+// are pull requests.  Github treats every pull request as an issue too.
+type PullRequestRef struct {
+	URL     string
+	HTMLURL string `json:"html_url"`
+}
+
+// Returns true if an issue is really a pull request.
+func (iss *Issue) IsPullRequest() bool {
+	return iss.PullRequest != nil
 }
 
-// User represents a github user entry.
+// User represents a github user entry.  Bio is only filled in by
+// GetUser().
 type User struct {
 	Login   string
 	ID      int
 	HTMLURL string `json:"html_url"`
+	Bio     string
+}
+
+// Gist represents a github gist.  Gist listings leave out the content
+// of the files.
+type Gist struct {
+	ID          string
+	Description string
+	HTMLURL     string `json:"html_url"`
+	Public      bool
+	Owner       *User
+	Files       map[string]*GistFile
+}
+
+// GistFile represents one file in a gist.
+type GistFile struct {
+	Filename  string
+	Size      int
+	Content   string
+	Truncated bool
 }
 
 // Label represents a github issue label.
@@ -51,11 +97,32 @@ type Label struct {
 	URL  string
 }
 
+// Milestone represents a github milestone.
+type Milestone struct {
+	Number int
+	Title  string
+	State  string
+}
+
+// Comment represents a single comment on a github issue.
+type Comment struct {
+	ID        int
+	HTMLURL   string `json:"html_url"`
+	User      *User
+	Body      string
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 // SearchIssues() a function that sends a github issue list query and
 // returns the full results or an error.  Github limits the number
 // of entries per 'GET' so this function parses the response to fetch
 // subsequenty query results until it reaches the end of the list.
 func SearchIssues(base string, params map[string]string) ([]*Issue, error) {
+	return searchIssues(base, "", params)
+}
+
+func searchIssues(base string, tok string, params map[string]string) ([]*Issue, error) {
 	var result []*Issue
 	p := ""
 	for k, v := range params {
@@ -65,43 +132,308 @@ func SearchIssues(base string, params map[string]string) ([]*Issue, error) {
 			p += "&" + url.QueryEscape(k) + "=" + url.QueryEscape(v)
 		}
 	}
-	fmt.Println(base + p)
-	resp, err := http.Get(base + p)
+	l.Debug(base + p)
+	err := getPages(base+p, tok, func(resp *http.Response) error {
+		var err error
+		result, err = decodeIssueList(result, resp)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	if result, err = decodeIssueList(result, resp); err != nil {
+	return result, nil
+}
+
+// Fetch all the comments for a particular issue from github.
+//
+// This function assumes that base includes a full repo path for a query.
+//   e.g. https://api.github.com/repos/OWNER/REPO
+//
+func GetComments(base string, tok string, num int) ([]*Comment, error) {
+	var result []*Comment
+	addr := base + fmt.Sprintf("/%d/comments?per_page=100", num)
+	err := getPages(addr, tok, func(resp *http.Response) error {
+		var err error
+		result, err = decodeCommentList(result, resp)
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
-	for link := getLink(resp); link != ""; link = getLink(resp) {
-		if resp, err = http.Get(link); err != nil {
-			return nil, err
-		}
-		if result, err = decodeIssueList(result, resp); err != nil {
-			return nil, err
-		}
-	}
 	return result, nil
 }
 
-// Fetch a particular issue from github based on its number.
+// Fetch a particular issue from github based on its number with an
+// optional authentication token.
 //
 // This function assumes that base includes a full repo path for a query.
 //   e.g. https://api.github.com/repos/OWNER/REPO
 //
-func GetIssue(base string, num int) (*Issue, error) {
+func GetIssue(base string, tok string, num int) (*Issue, error) {
 	var iss Issue
-	resp, err := http.Get(base + fmt.Sprintf("/%d", num))
+	resp, err := get(base+fmt.Sprintf("/%d", num), tok)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("issue query failed: %s", resp.Status)
+	}
 	if err = json.NewDecoder(resp.Body).Decode(&iss); err != nil {
 		return nil, err
 	}
 	return &iss, nil
 }
 
+// Issue a GET request to github with an optional authentication token.
+func get(addr string, tok string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	if tok != "" {
+		req.Header.Set("Authorization", tok)
+	}
+	return Client.Do(req)
+}
+
+// Issue a GET request and then keep following the "next" links in the
+// responses until there are no more pages.  Each response is handed to
+// the decode function which is responsible for closing the body.
+func getPages(addr string, tok string, decode func(*http.Response) error) error {
+	for addr != "" {
+		resp, err := get(addr, tok)
+		if err != nil {
+			return err
+		}
+		if err = decode(resp); err != nil {
+			return err
+		}
+		addr = getLink(resp)
+	}
+	return nil
+}
+
+// Send a request with an optional JSON body to github and check that it
+// returned the expected status.
+func send(method string, addr string, tok string, body interface{}, status int) error {
+	return sendJSON(method, addr, tok, body, status, nil)
+}
+
+// Like send() but also decodes the JSON response into out if out is not
+// nil.
+func sendJSON(method string, addr string, tok string, body interface{}, status int, out interface{}) error {
+	if tok == "" {
+		return fmt.Errorf("Token required for %s %s", method, addr)
+	}
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("Error marshalling request: %s", err.Error())
+		}
+		rd = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, addr, rd)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-type", "application/json")
+	}
+	req.Header.Set("Authorization", tok)
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		return fmt.Errorf("Github Response error: %s", resp.Status)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// The format of a response from the github search API
+type searchResult struct {
+	TotalCount int `json:"total_count"`
+	Items      []*Issue
+}
+
+// Run a github issue search query and return all of the matching issues.
+// The query uses github's search syntax.  See:
+//   https://help.github.com/articles/searching-issues-and-pull-requests/
+//
+// Github never returns more than 1000 results for a search.
+func SearchQuery(api string, tok string, q string) ([]*Issue, error) {
+	var result []*Issue
+	addr := api + "search/issues?per_page=100&q=" + url.QueryEscape(q)
+	err := getPages(addr, tok, func(resp *http.Response) error {
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("search query failed: %s", resp.Status)
+		}
+		var sr searchResult
+		if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
+			return err
+		}
+		result = append(result, sr.Items...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// NewIssue holds the fields for creating an issue.  See:
+//   https://developer.github.com/v3/issues/#create-an-issue
+type NewIssue struct {
+	Title     string   `json:"title"`
+	Body      string   `json:"body,omitempty"`
+	Labels    []string `json:"labels,omitempty"`
+	Assignees []string `json:"assignees,omitempty"`
+}
+
+// Create an issue and return it as github stored it.
+func CreateIssue(base string, tok string, ni *NewIssue) (*Issue, error) {
+	var iss Issue
+	if err := sendJSON(http.MethodPost, base, tok, ni, http.StatusCreated, &iss); err != nil {
+		return nil, err
+	}
+	return &iss, nil
+}
+
+// Add a comment to an issue.
+func PostComment(base string, tok string, num int, body string) error {
+	addr := base + fmt.Sprintf("/%d/comments", num)
+	return send(http.MethodPost, addr, tok, map[string]string{"body": body}, http.StatusCreated)
+}
+
+// Add labels to an issue.  Labels that don't exist in the repository are
+// created.
+func AddLabels(base string, tok string, num int, labels []string) error {
+	addr := base + fmt.Sprintf("/%d/labels", num)
+	return send(http.MethodPost, addr, tok, labels, http.StatusOK)
+}
+
+// Remove a label from an issue.
+func RemoveLabel(base string, tok string, num int, label string) error {
+	addr := base + fmt.Sprintf("/%d/labels/%s", num, url.PathEscape(label))
+	return send(http.MethodDelete, addr, tok, nil, http.StatusOK)
+}
+
+// Fetch all the milestones (open and closed) of a repository.
+//
+// Like the issue functions this assumes that base is the issues URL for a
+// repository.  e.g. https://api.github.com/repos/OWNER/REPO/issues
+func GetMilestones(base string, tok string) ([]*Milestone, error) {
+	var result []*Milestone
+	addr := strings.TrimSuffix(base, "/issues") + "/milestones?state=all&per_page=100"
+	err := getPages(addr, tok, func(resp *http.Response) error {
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("milestone query failed: %s", resp.Status)
+		}
+		var dm []*Milestone
+		if err := json.NewDecoder(resp.Body).Decode(&dm); err != nil {
+			return err
+		}
+		result = append(result, dm...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Fetch all the labels of a repository.  Like GetMilestones() this
+// assumes that base is the issues URL for a repository.
+func GetLabels(base string, tok string) ([]*Label, error) {
+	var result []*Label
+	addr := strings.TrimSuffix(base, "/issues") + "/labels?per_page=100"
+	err := getPages(addr, tok, func(resp *http.Response) error {
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("label query failed: %s", resp.Status)
+		}
+		var dl []*Label
+		if err := json.NewDecoder(resp.Body).Decode(&dl); err != nil {
+			return err
+		}
+		result = append(result, dl...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Return a user's permission on a repository:  "admin", "maintain",
+// "write", "triage", "read" or "none".  Like GetMilestones() this assumes
+// that base is the issues URL for a repository.
+func GetPermission(base string, tok string, login string) (string, error) {
+	var out struct {
+		Permission string `json:"permission"`
+		RoleName   string `json:"role_name"`
+	}
+	addr := strings.TrimSuffix(base, "/issues") + "/collaborators/" + url.PathEscape(login) + "/permission"
+	if err := getJSON(addr, tok, "permission", &out); err != nil {
+		return "", err
+	}
+	// "permission" only distinguishes admin, write, read and none
+	switch out.RoleName {
+	case "maintain", "triage":
+		return out.RoleName, nil
+	}
+	return out.Permission, nil
+}
+
+// Fetch a github user's profile.  api is the root of the github API.
+func GetUser(api string, tok string, login string) (*User, error) {
+	var u User
+	if err := getJSON(api+"users/"+url.PathEscape(login), tok, "user", &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// Fetch the first page of a user's public gists, most recently created
+// first.  api is the root of the github API.
+func GetGists(api string, tok string, login string) ([]*Gist, error) {
+	var gists []*Gist
+	err := getJSON(api+"users/"+url.PathEscape(login)+"/gists?per_page=30", tok, "gist", &gists)
+	return gists, err
+}
+
+// Fetch a gist along with the content of its files.
+func GetGist(api string, tok string, id string) (*Gist, error) {
+	var g Gist
+	if err := getJSON(api+"gists/"+url.PathEscape(id), tok, "gist", &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// Issue a GET request and decode the JSON response into out.  what names
+// the thing being fetched for errors.
+func getJSON(addr string, tok string, what string, out interface{}) error {
+	resp, err := get(addr, tok)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s query failed: %s", what, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
 // Modify a github issue.
 //
 // The map argument is going to get encoded into a JSON request to send
@@ -109,30 +441,39 @@ func GetIssue(base string, num int) (*Issue, error) {
 //   https://developer.github.com/v3/issues/#edit-an-issue
 //
 func ModIssue(base string, tok string, num int, fields map[string]interface{}) error {
+	_, err := EditIssue(base, tok, num, fields)
+	return err
+}
+
+// Modify a github issue like ModIssue() and return the issue as it is
+// after the change.
+func EditIssue(base string, tok string, num int, fields map[string]interface{}) (*Issue, error) {
 	if tok == "" {
-		return fmt.Errorf("Token required for ModIssue")
+		return nil, fmt.Errorf("Token required for ModIssue")
 	}
 
 	addr := base + fmt.Sprintf("/%d", num)
 
-	json, err := json.Marshal(fields)
+	data, err := json.Marshal(fields)
 	if err != nil {
-		fmt.Errorf("Error marshalling request: %s", err.Error())
+		return nil, fmt.Errorf("Error marshalling request: %s", err.Error())
 	}
 
-	req, err := http.NewRequest(http.MethodPatch, addr, bytes.NewReader(json))
+	req, err := http.NewRequest(http.MethodPatch, addr, bytes.NewReader(data))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req.Header.Set("Content-type", "application/json")
 	req.Header.Set("Authorization", tok)
 
-	client := http.Client{}
-	resp, err := client.Do(req)
+	resp, err := Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
 
-	if err == nil && resp.StatusCode != 200 {
-		err = fmt.Errorf("Github Response error: %s", resp.Status)
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Github Response error: %s", resp.Status)
 	}
 
 	/*
@@ -143,7 +484,11 @@ func ModIssue(base string, tok string, num int, fields map[string]interface{}) e
 		fmt.Println("Response Body:\n", string(body))
 	*/
 
-	return err
+	var iss Issue
+	if err = json.NewDecoder(resp.Body).Decode(&iss); err != nil {
+		return nil, err
+	}
+	return &iss, nil
 }
 
 // Github Link Format
@@ -190,23 +535,113 @@ func decodeIssueList(issues []*Issue, resp *http.Response) ([]*Issue, error) {
 
 }
 
+// This function decodes the JSON response and appends any comments found
+// therein into the slice of comments
+func decodeCommentList(comments []*Comment, resp *http.Response) ([]*Comment, error) {
+	defer resp.Body.Close()
+	var dc []*Comment
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("comment query failed: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&dc); err != nil {
+		return nil, err
+	}
+	return append(comments, dc...), nil
+}
+
 // This struct is for convenience when issuing multiple queries to the
 // same repo with (roughty) the same set of base query parameters.
 type Agent struct {
 	base        string
+	api         string
+	repo        string
 	token       string
 	fixedParams map[string]string
+	observe     func(*Call)
+	snapshot    func(*Snapshot)
 }
 
+// A request that an agent made to change something on github.  (See
+// Agent.WithObserver())
+type Call struct {
+	Method string
+	Path   string      // relative to the API root
+	Issue  int         // the issue changed
+	Body   interface{} // the JSON request body if any
+	Err    error
+}
+
+// The fields of an issue that an edit changed as they were before the
+// edit.  (See Agent.WithSnapshots())
+type Snapshot struct {
+	Issue     int
+	Fields    []string // the fields that were edited
+	State     string
+	Assignees []string
+	Labels    []string
+	Milestone int       // 0 for none
+	UpdatedAt time.Time // the issue's updated_at after the edit
+}
+
+// Fields of an edit that are saved in snapshots
+var snapshotFields = []string{"state", "assignees", "labels", "milestone"}
+
 // This function is a constructor for a generic github issue searcher
 func NewAgent(base string, params map[string]string) *Agent {
-	return &Agent{base, "", params}
+	return &Agent{base: base, fixedParams: params}
 }
 
 // This function is a constructor for a github issue searcher that
 // always queries issues from a specific owner/repo.
 func NewRepoAgent(name string) *Agent {
-	return NewAgent(APIURL+name+"/issues", make(map[string]string))
+	return NewRepoAgentURL(APIRoot, name)
+}
+
+// This function is a constructor for a github issue searcher for a
+// specific owner/repo that uses the API rooted at api instead of the
+// public github API.  (e.g. a github enterprise server or a test server)
+func NewRepoAgentURL(api string, name string) *Agent {
+	if !strings.HasSuffix(api, "/") {
+		api += "/"
+	}
+	a := NewAgent(api+"repos/"+name+"/issues", make(map[string]string))
+	a.api = api
+	a.repo = name
+	return a
+}
+
+// Return the owner/repo name of the repository the agent manages or ""
+// if the agent isn't tied to a specific repository.
+func (s *Agent) Repo() string {
+	return s.repo
+}
+
+// Return a copy of the agent that passes each change it makes to github
+// to fn once the change is done.
+func (s *Agent) WithObserver(fn func(*Call)) *Agent {
+	c := *s
+	c.observe = fn
+	return &c
+}
+
+// Return a copy of the agent that passes fn a snapshot of each issue it
+// is about to edit once the edit is done.  A nil fn turns snapshots off.
+func (s *Agent) WithSnapshots(fn func(*Snapshot)) *Agent {
+	c := *s
+	c.snapshot = fn
+	return &c
+}
+
+// Report a change to the agent's observer.
+func (s *Agent) report(method string, addr string, num int, body interface{}, err error) {
+	if s.observe == nil {
+		return
+	}
+	path := addr
+	if s.api != "" {
+		path = strings.TrimPrefix(addr, s.api)
+	}
+	s.observe(&Call{Method: method, Path: path, Issue: num, Body: body, Err: err})
 }
 
 // This function adds search parameters to the fixed parameters for the searcher.
@@ -225,7 +660,14 @@ func (s *Agent) FetchIssues(params map[string]string) ([]*Issue, error) {
 	for k, v := range params {
 		p[k] = v
 	}
-	return SearchIssues(s.base, p)
+	return searchIssues(s.base, s.token, p)
+}
+
+// Read all the comments for a specific issue by its issue number.
+func (s *Agent) FetchComments(num int) ([]*Comment, error) {
+	log := l.WithField("method", "comments")
+	log.Debugf("%s/%d", s.base, num)
+	return GetComments(s.base, s.token, num)
 }
 
 // Set the authentication token for a given agent.
@@ -233,18 +675,110 @@ func (s *Agent) SetToken(token string) {
 	s.token = token
 }
 
+// Return the authentication token for a given agent.
+func (s *Agent) Token() string {
+	return s.token
+}
+
 // Read a specific issue by its issue number.
 func (s *Agent) GetIssue(num int) (*Issue, error) {
 	log := l.WithField("method", "find")
 	log.Debugf("%s/%d", s.base, num)
-	return GetIssue(s.base, num)
+	return GetIssue(s.base, s.token, num)
 }
 
 // Modify an issue in some way.  See ModIssue()
 //
 // Other methods will build higher level changes on top of this.
 func (s *Agent) modIssue(num int, m map[string]interface{}) error {
-	return ModIssue(s.base, s.token, num, m)
+	var snap *Snapshot
+	if s.snapshot != nil {
+		snap = s.takeSnapshot(num, m)
+	}
+	iss, err := EditIssue(s.base, s.token, num, m)
+	s.report(http.MethodPatch, s.base+fmt.Sprintf("/%d", num), num, m, err)
+	if err == nil && snap != nil {
+		snap.UpdatedAt = iss.UpdatedAt
+		s.snapshot(snap)
+	}
+	return err
+}
+
+// Make a change that doesn't go through modIssue(), saving a snapshot of
+// the field it changes first.  Such changes don't return the issue so it
+// is read again afterwards for the time of the change.
+func (s *Agent) withSnapshot(num int, field string, change func() error) error {
+	var snap *Snapshot
+	if s.snapshot != nil {
+		snap = s.takeSnapshot(num, map[string]interface{}{field: nil})
+	}
+	err := change()
+	if err == nil && snap != nil {
+		iss, gerr := s.GetIssue(num)
+		if gerr != nil {
+			l.WithField("method", "snapshot").Infof("Unable to read %s/%d after change: %s", s.base, num, gerr)
+			return nil
+		}
+		snap.UpdatedAt = iss.UpdatedAt
+		s.snapshot(snap)
+	}
+	return err
+}
+
+// Save the fields of an issue that an edit will change.  Returns nil if
+// the edit changes none of the saved fields or the issue can't be read,
+// in which case the edit can't be undone.
+func (s *Agent) takeSnapshot(num int, m map[string]interface{}) *Snapshot {
+	snap := &Snapshot{Issue: num}
+	for _, f := range snapshotFields {
+		if _, ok := m[f]; ok {
+			snap.Fields = append(snap.Fields, f)
+		}
+	}
+	if len(snap.Fields) == 0 {
+		return nil
+	}
+	iss, err := s.GetIssue(num)
+	if err != nil {
+		l.WithField("method", "snapshot").Infof("Unable to snapshot %s/%d: %s", s.base, num, err)
+		return nil
+	}
+	snap.State = iss.State
+	snap.Assignees = []string{}
+	for _, u := range iss.Assignees {
+		snap.Assignees = append(snap.Assignees, u.Login)
+	}
+	snap.Labels = []string{}
+	for _, lb := range iss.Labels {
+		snap.Labels = append(snap.Labels, lb.Name)
+	}
+	if iss.Milestone != nil {
+		snap.Milestone = iss.Milestone.Number
+	}
+	return snap
+}
+
+// Put back the fields saved in a snapshot.
+func (s *Agent) Restore(snap *Snapshot) error {
+	log := l.WithField("method", "restore")
+	log.Debugf("%s/%d %v", s.base, snap.Issue, snap.Fields)
+	m := make(map[string]interface{})
+	for _, f := range snap.Fields {
+		switch f {
+		case "state":
+			m[f] = snap.State
+		case "assignees":
+			m[f] = snap.Assignees
+		case "labels":
+			m[f] = snap.Labels
+		case "milestone":
+			m[f] = nil
+			if snap.Milestone > 0 {
+				m[f] = snap.Milestone
+			}
+		}
+	}
+	return s.modIssue(snap.Issue, m)
 }
 
 // Close an existing issue
@@ -276,3 +810,115 @@ func (s *Agent) AssignIssue(num int, user string) error {
 	ulist := []string{user}
 	return s.modIssue(num, map[string]interface{}{"assignees": ulist})
 }
+
+// Set the complete list of users assigned to this issue
+func (s *Agent) SetAssignees(num int, users []string) error {
+	log := l.WithField("method", "assignees")
+	log.Debugf("%s/%d to %v", s.base, num, users)
+	return s.modIssue(num, map[string]interface{}{"assignees": users})
+}
+
+// Replace the labels of this issue
+func (s *Agent) SetLabels(num int, labels []string) error {
+	log := l.WithField("method", "labels")
+	log.Debugf("%s/%d to %v", s.base, num, labels)
+	return s.modIssue(num, map[string]interface{}{"labels": labels})
+}
+
+// Add a comment to this issue
+func (s *Agent) CommentIssue(num int, body string) error {
+	log := l.WithField("method", "comment")
+	log.Debugf("%s/%d", s.base, num)
+	err := PostComment(s.base, s.token, num, body)
+	s.report(http.MethodPost, s.base+fmt.Sprintf("/%d/comments", num), num, map[string]string{"body": body}, err)
+	return err
+}
+
+// Add labels to this issue
+func (s *Agent) LabelIssue(num int, labels ...string) error {
+	log := l.WithField("method", "label")
+	log.Debugf("%s/%d %v", s.base, num, labels)
+	return s.withSnapshot(num, "labels", func() error {
+		err := AddLabels(s.base, s.token, num, labels)
+		s.report(http.MethodPost, s.base+fmt.Sprintf("/%d/labels", num), num, labels, err)
+		return err
+	})
+}
+
+// Remove a label from this issue
+func (s *Agent) UnlabelIssue(num int, label string) error {
+	log := l.WithField("method", "unlabel")
+	log.Debugf("%s/%d %s", s.base, num, label)
+	return s.withSnapshot(num, "labels", func() error {
+		err := RemoveLabel(s.base, s.token, num, label)
+		s.report(http.MethodDelete, s.base+fmt.Sprintf("/%d/labels/%s", num, url.PathEscape(label)), num, nil, err)
+		return err
+	})
+}
+
+// Set the milestone for this issue by milestone number.  A number of 0
+// removes the issue from its milestone.
+func (s *Agent) SetMilestone(num int, milestone int) error {
+	log := l.WithField("method", "milestone")
+	log.Debugf("%s/%d %d", s.base, num, milestone)
+	var m interface{}
+	if milestone > 0 {
+		m = milestone
+	}
+	return s.modIssue(num, map[string]interface{}{"milestone": m})
+}
+
+// Read all the milestones of the repository
+func (s *Agent) FetchMilestones() ([]*Milestone, error) {
+	return GetMilestones(s.base, s.token)
+}
+
+// Create a new issue in the agent's repository.
+func (s *Agent) CreateIssue(ni *NewIssue) (*Issue, error) {
+	log := l.WithField("method", "create")
+	log.Debugf("%s %q", s.base, ni.Title)
+	iss, err := CreateIssue(s.base, s.token, ni)
+	num := 0
+	if iss != nil {
+		num = iss.Number
+	}
+	s.report(http.MethodPost, s.base, num, ni, err)
+	return iss, err
+}
+
+// Read all the labels of the repository
+func (s *Agent) FetchLabels() ([]*Label, error) {
+	return GetLabels(s.base, s.token)
+}
+
+// Return a user's permission on the repository.
+func (s *Agent) GetPermission(login string) (string, error) {
+	return GetPermission(s.base, s.token, login)
+}
+
+// Fetch a github user's profile.
+func (s *Agent) GetUser(login string) (*User, error) {
+	return GetUser(s.api, s.token, login)
+}
+
+// Fetch a user's most recent public gists.
+func (s *Agent) FetchGists(login string) ([]*Gist, error) {
+	return GetGists(s.api, s.token, login)
+}
+
+// Fetch a gist along with the content of its files.
+func (s *Agent) GetGist(id string) (*Gist, error) {
+	return GetGist(s.api, s.token, id)
+}
+
+// Search the agent's repository for issues using github's search syntax.
+// Pull requests are left out.
+func (s *Agent) Search(q string) ([]*Issue, error) {
+	log := l.WithField("method", "search")
+	if s.repo == "" {
+		return nil, fmt.Errorf("Search requires a repository agent")
+	}
+	q = "repo:" + s.repo + " is:issue " + q
+	log.Debug(q)
+	return SearchQuery(s.api, s.token, q)
+}
@@ -0,0 +1,526 @@
+// An in-process fake of the parts of the github API that the issuebot
+// uses.  The fake keeps all of its state in memory, paginates list
+// responses with Link headers the same way github does and can be told
+// to fail requests or to run out of rate limit so that error handling
+// can be exercised without talking to the real github.
+//
+// Typical use:
+//
+//	s := githubtest.NewServer()
+//	defer s.Close()
+//	s.AddIssue("owner/repo", &github.Issue{Title: "Crash on start"})
+//	a := github.NewRepoAgentURL(s.URL, "owner/repo")
+package githubtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctelfer-docker/slkiss/github"
+)
+
+// Default number of entries per page when a request doesn't specify one
+const DefaultPerPage = 30
+
+// The largest page size github allows
+const maxPerPage = 100
+
+// Server is a fake github API server.  Server.URL is the root of the API
+// and can be passed to github.NewRepoAgentURL().
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	repos     map[string]*repo
+	users     map[string]*user
+	assignees map[string]*user
+	bios      map[string]string
+	gists     []*gist
+	token     string
+	faults    []fault
+	limit     int
+	remaining int
+	reset     time.Time
+	perPage   int
+	nextID    int
+	requests  []string
+}
+
+// A response to return instead of handling the next request
+type fault struct {
+	status  int
+	message string
+}
+
+// Create and start a new fake github server with no repositories.
+func NewServer() *Server {
+	s := &Server{
+		repos:     make(map[string]*repo),
+		users:     make(map[string]*user),
+		assignees: make(map[string]*user),
+		bios:      make(map[string]string),
+		limit:     -1,
+		perPage:   DefaultPerPage,
+		nextID:    1000,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Set the default page size for list responses.
+func (s *Server) SetPerPage(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.perPage = n
+}
+
+// Require requests that modify state to carry this Authorization header.
+// Requests that carry a different Authorization header are always
+// rejected.  An empty token disables authentication.
+func (s *Server) RequireToken(tok string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = tok
+}
+
+// Fail the next request with the given HTTP status and error message
+// instead of handling it.  Multiple calls queue multiple failures.
+func (s *Server) FailNext(status int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, fault{status, message})
+}
+
+// Allow only n more requests before responding as github does when the
+// rate limit is exhausted.  A negative n removes the rate limit.
+func (s *Server) SetRateLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = n
+	s.remaining = n
+	s.reset = time.Now().Add(time.Hour).Truncate(time.Second)
+}
+
+// Return the requests the server has received so far as "METHOD URI".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Add a user that can be assigned to issues.  Once any users have been
+// added, assigning an issue to an unknown user fails the way github does
+// for users without access to the repository.
+func (s *Server) AddUser(login string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.assignees[strings.ToLower(login)] = s.getUser(login)
+}
+
+// Give a user a permission on a repository creating both if needed.
+// perm is one of github's repository roles:  "admin", "maintain",
+// "write", "triage" or "read".  Users default to "read".
+func (s *Server) SetPermission(repo string, login string, perm string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.getRepo(repo).perms[strings.ToLower(s.getUser(login).Login)] = perm
+}
+
+// Set the bio in a user's profile creating the user if needed.
+func (s *Server) SetBio(login string, bio string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bios[strings.ToLower(s.getUser(login).Login)] = bio
+}
+
+// Add a public gist owned by a user creating the user if needed.  files
+// maps file names to their content.  Returns the gist's ID.
+func (s *Server) AddGist(login string, description string, files map[string]string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := fmt.Sprintf("%x", s.newID())
+	g := &gist{
+		ID:          id,
+		Description: description,
+		HTMLURL:     "https://gist.github.com/" + id,
+		Public:      true,
+		Owner:       s.getUser(login),
+		Files:       make(map[string]*gistFile),
+		CreatedAt:   time.Now().UTC(),
+	}
+	for name, content := range files {
+		g.Files[name] = &gistFile{Filename: name, Size: len(content), Content: content}
+	}
+	s.gists = append(s.gists, g)
+	return id
+}
+
+// Add a label to a repository creating the repository if needed.
+func (s *Server) AddLabel(repo string, name string, color string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.getRepo(repo).getLabel(name).Color = color
+}
+
+// Add a milestone to a repository creating the repository if needed.
+// Returns the milestone number.
+func (s *Server) AddMilestone(repo string, title string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rp := s.getRepo(repo)
+	m := &milestone{Number: len(rp.milestones) + 1, Title: title, State: "open"}
+	rp.milestones[m.Number] = m
+	return m.Number
+}
+
+// Add an issue to a repository creating the repository if needed.  The
+// issue is copied and any fields left unset are filled in.  Labels and
+// users referenced by the issue are created as needed.  If the issue's
+// PullRequest is set it is added as a pull request, which github lists
+// along with the issues.  Returns the issue number.
+func (s *Server) AddIssue(repo string, iss *github.Issue) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rp := s.getRepo(repo)
+	author := "octocat"
+	if iss.User != nil {
+		author = iss.User.Login
+	}
+	i := s.newIssue(rp, author, iss.Title, iss.Body)
+	if iss.State != "" {
+		i.State = iss.State
+	}
+	if !iss.CreatedAt.IsZero() {
+		i.CreatedAt = iss.CreatedAt
+		i.UpdatedAt = iss.CreatedAt
+	}
+	if !iss.UpdatedAt.IsZero() {
+		i.UpdatedAt = iss.UpdatedAt
+	}
+	if i.State == "closed" {
+		t := i.UpdatedAt
+		if !iss.ClosedAt.IsZero() {
+			t = iss.ClosedAt
+		}
+		i.ClosedAt = &t
+	}
+	for _, l := range iss.Labels {
+		i.Labels = append(i.Labels, rp.getLabel(l.Name))
+	}
+	var logins []string
+	for _, u := range iss.Assignees {
+		logins = append(logins, u.Login)
+	}
+	if len(logins) == 0 && iss.Assignee != nil {
+		logins = append(logins, iss.Assignee.Login)
+	}
+	for _, login := range logins {
+		i.Assignees = append(i.Assignees, s.getUser(login))
+	}
+	i.fixAssignee()
+	if iss.Milestone != nil {
+		i.Milestone = rp.milestones[iss.Milestone.Number]
+	}
+	i.Locked = iss.Locked
+	if iss.PullRequest != nil {
+		i.HTMLURL = fmt.Sprintf("https://github.com/%s/pull/%d", rp.name, i.Number)
+		i.PullRequest = &pullRequest{
+			URL:     fmt.Sprintf("https://api.github.com/repos/%s/pulls/%d", rp.name, i.Number),
+			HTMLURL: i.HTMLURL,
+		}
+	}
+	return i.Number
+}
+
+// Add a comment to an existing issue.  Returns false if the issue does
+// not exist.
+func (s *Server) AddComment(repo string, num int, login string, body string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rp, ok := s.repos[repo]
+	if !ok {
+		return false
+	}
+	i, ok := rp.issues[num]
+	if !ok {
+		return false
+	}
+	s.newComment(rp, i, login, body, i.UpdatedAt)
+	return true
+}
+
+// Return a copy of the current state of an issue as the github package
+// would decode it or nil if there is no such issue.
+func (s *Server) Issue(repo string, num int) *github.Issue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rp, ok := s.repos[repo]
+	if !ok {
+		return nil
+	}
+	i, ok := rp.issues[num]
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(i)
+	if err != nil {
+		panic(err)
+	}
+	var iss github.Issue
+	if err = json.Unmarshal(data, &iss); err != nil {
+		panic(err)
+	}
+	return &iss
+}
+
+// Return the bodies of the comments on an issue in order.
+func (s *Server) Comments(repo string, num int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var bodies []string
+	if rp, ok := s.repos[repo]; ok {
+		if i, ok := rp.issues[num]; ok {
+			for _, c := range i.comments {
+				bodies = append(bodies, c.Body)
+			}
+		}
+	}
+	return bodies
+}
+
+// --------------------------- REQUEST HANDLING ---------------------------
+
+// The main entry point for all requests
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+
+	if len(s.faults) > 0 {
+		f := s.faults[0]
+		s.faults = s.faults[1:]
+		writeError(w, f.status, f.message)
+		return
+	}
+
+	if s.limit >= 0 {
+		w.Header().Set("X-RateLimit-Limit", fmt.Sprint(s.limit))
+		w.Header().Set("X-RateLimit-Reset", fmt.Sprint(s.reset.Unix()))
+		if s.remaining == 0 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			writeError(w, http.StatusForbidden, "API rate limit exceeded")
+			return
+		}
+		s.remaining--
+		w.Header().Set("X-RateLimit-Remaining", fmt.Sprint(s.remaining))
+	}
+
+	if s.token != "" {
+		auth := r.Header.Get("Authorization")
+		if auth != "" && auth != s.token {
+			writeError(w, http.StatusUnauthorized, "Bad credentials")
+			return
+		}
+		if auth == "" && r.Method != http.MethodGet {
+			writeError(w, http.StatusUnauthorized, "Requires authentication")
+			return
+		}
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(path) == 2 && path[0] == "search" && path[1] == "issues":
+		s.searchIssues(w, r)
+	case len(path) >= 2 && path[0] == "users":
+		s.serveUser(w, r, path[1], path[2:])
+	case len(path) == 2 && path[0] == "gists":
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) { s.getGist(w, r, path[1]) },
+		})
+	case len(path) >= 4 && path[0] == "repos":
+		rp, ok := s.repos[path[1]+"/"+path[2]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		s.serveRepo(w, r, rp, path[3:])
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+// Dispatch a request under /repos/OWNER/REPO/
+func (s *Server) serveRepo(w http.ResponseWriter, r *http.Request, rp *repo, path []string) {
+	switch path[0] {
+	case "issues":
+		if len(path) == 1 {
+			route(w, r, map[string]http.HandlerFunc{
+				http.MethodGet:  func(w http.ResponseWriter, r *http.Request) { s.listIssues(w, r, rp) },
+				http.MethodPost: func(w http.ResponseWriter, r *http.Request) { s.createIssue(w, r, rp) },
+			})
+			return
+		}
+		var num int
+		if _, err := fmt.Sscanf(path[1], "%d", &num); err != nil {
+			writeError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		i, ok := rp.issues[num]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		s.serveIssue(w, r, rp, i, path[2:])
+	case "labels":
+		if len(path) == 1 {
+			route(w, r, map[string]http.HandlerFunc{
+				http.MethodGet:  func(w http.ResponseWriter, r *http.Request) { s.listLabels(w, r, rp) },
+				http.MethodPost: func(w http.ResponseWriter, r *http.Request) { s.createLabel(w, r, rp) },
+			})
+			return
+		}
+		l, ok := rp.labels[strings.ToLower(path[1])]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) { writeJSON(w, http.StatusOK, l) },
+			http.MethodDelete: func(w http.ResponseWriter, r *http.Request) {
+				rp.deleteLabel(l)
+				w.WriteHeader(http.StatusNoContent)
+			},
+		})
+	case "milestones":
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) { s.listMilestones(w, r, rp) },
+		})
+	case "collaborators":
+		if len(path) != 3 || path[2] != "permission" {
+			writeError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) { s.getPermission(w, r, rp, path[1]) },
+		})
+	case "assignees":
+		if len(path) == 1 {
+			route(w, r, map[string]http.HandlerFunc{
+				http.MethodGet: func(w http.ResponseWriter, r *http.Request) { s.listAssignees(w, r) },
+			})
+			return
+		}
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) {
+				if _, ok := s.assignees[strings.ToLower(path[1])]; ok {
+					w.WriteHeader(http.StatusNoContent)
+				} else {
+					writeError(w, http.StatusNotFound, "Not Found")
+				}
+			},
+		})
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+// Dispatch a request under /users/LOGIN
+func (s *Server) serveUser(w http.ResponseWriter, r *http.Request, login string, path []string) {
+	u, ok := s.users[strings.ToLower(login)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	switch {
+	case len(path) == 0:
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, &profile{u, s.bios[strings.ToLower(u.Login)]})
+			},
+		})
+	case len(path) == 1 && path[0] == "gists":
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) { s.listGists(w, r, u) },
+		})
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+// Dispatch a request under /repos/OWNER/REPO/issues/NUM
+func (s *Server) serveIssue(w http.ResponseWriter, r *http.Request, rp *repo, i *issue, path []string) {
+	if len(path) == 0 {
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet:   func(w http.ResponseWriter, r *http.Request) { writeJSON(w, http.StatusOK, i) },
+			http.MethodPatch: func(w http.ResponseWriter, r *http.Request) { s.editIssue(w, r, rp, i) },
+		})
+		return
+	}
+	switch path[0] {
+	case "comments":
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet:  func(w http.ResponseWriter, r *http.Request) { s.listComments(w, r, i) },
+			http.MethodPost: func(w http.ResponseWriter, r *http.Request) { s.createComment(w, r, rp, i) },
+		})
+	case "labels":
+		if len(path) == 2 {
+			route(w, r, map[string]http.HandlerFunc{
+				http.MethodDelete: func(w http.ResponseWriter, r *http.Request) { s.removeLabel(w, r, i, path[1]) },
+			})
+			return
+		}
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet:    func(w http.ResponseWriter, r *http.Request) { writeJSON(w, http.StatusOK, i.Labels) },
+			http.MethodPost:   func(w http.ResponseWriter, r *http.Request) { s.addLabels(w, r, rp, i, false) },
+			http.MethodPut:    func(w http.ResponseWriter, r *http.Request) { s.addLabels(w, r, rp, i, true) },
+			http.MethodDelete: func(w http.ResponseWriter, r *http.Request) { s.clearLabels(w, r, i) },
+		})
+	case "assignees":
+		route(w, r, map[string]http.HandlerFunc{
+			http.MethodPost:   func(w http.ResponseWriter, r *http.Request) { s.changeAssignees(w, r, i, true) },
+			http.MethodDelete: func(w http.ResponseWriter, r *http.Request) { s.changeAssignees(w, r, i, false) },
+		})
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+// Call the handler for the request's method or fail the request.
+func route(w http.ResponseWriter, r *http.Request, methods map[string]http.HandlerFunc) {
+	h, ok := methods[r.Method]
+	if !ok {
+		writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+	h(w, r)
+}
+
+// Write a github style error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{
+		"message":           message,
+		"documentation_url": "https://developer.github.com/v3",
+	})
+}
+
+// Write a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Decode a JSON request body.  Writes an error response and returns false
+// on failure.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "Problems parsing JSON")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,504 @@
+package githubtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GET /repos/OWNER/REPO/issues
+func (s *Server) listIssues(w http.ResponseWriter, r *http.Request, rp *repo) {
+	q := r.URL.Query()
+	state := q.Get("state")
+	if state == "" {
+		state = "open"
+	}
+	var since time.Time
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "Validation Failed")
+			return
+		}
+		since = t
+	}
+	var labels []string
+	if v := q.Get("labels"); v != "" {
+		labels = strings.Split(v, ",")
+	}
+
+	var issues []*issue
+	for _, i := range rp.issues {
+		if state != "all" && i.State != state {
+			continue
+		}
+		if !since.IsZero() && i.UpdatedAt.Before(since) {
+			continue
+		}
+		if !matchAssignee(i, q.Get("assignee")) {
+			continue
+		}
+		if c := q.Get("creator"); c != "" && !strings.EqualFold(i.User.Login, c) {
+			continue
+		}
+		ok := true
+		for _, l := range labels {
+			ok = ok && i.hasLabel(l)
+		}
+		if ok {
+			issues = append(issues, i)
+		}
+	}
+	sortIssues(issues, q.Get("sort"), q.Get("direction"))
+
+	items := make([]interface{}, len(issues))
+	for n, i := range issues {
+		items[n] = i
+	}
+	s.writePage(w, r, items, nil)
+}
+
+// Filter on the assignee parameter of an issue list query
+func matchAssignee(i *issue, assignee string) bool {
+	switch assignee {
+	case "":
+		return true
+	case "none":
+		return len(i.Assignees) == 0
+	case "*":
+		return len(i.Assignees) > 0
+	}
+	return i.assignedTo(assignee)
+}
+
+// Sort issues the way github does for list and search queries.
+func sortIssues(issues []*issue, by string, dir string) {
+	key := func(i *issue) int64 {
+		switch by {
+		case "updated":
+			return i.UpdatedAt.UnixNano()
+		case "comments":
+			return int64(i.Comments)
+		}
+		return i.CreatedAt.UnixNano()
+	}
+	sort.SliceStable(issues, func(a, b int) bool {
+		ka, kb := key(issues[a]), key(issues[b])
+		if ka == kb {
+			ka, kb = int64(issues[a].Number), int64(issues[b].Number)
+		}
+		if dir == "asc" {
+			return ka < kb
+		}
+		return ka > kb
+	})
+}
+
+// The fields of a create or edit issue request
+type issueRequest struct {
+	Title     *string   `json:"title"`
+	Body      *string   `json:"body"`
+	State     *string   `json:"state"`
+	Assignee  *string   `json:"assignee"`
+	Assignees *[]string `json:"assignees"`
+	Labels    *[]string `json:"labels"`
+
+	// absent leaves the milestone alone while null clears it
+	Milestone json.RawMessage `json:"milestone"`
+}
+
+// POST /repos/OWNER/REPO/issues
+func (s *Server) createIssue(w http.ResponseWriter, r *http.Request, rp *repo) {
+	var req issueRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Title == nil || *req.Title == "" {
+		writeError(w, http.StatusUnprocessableEntity, "Validation Failed")
+		return
+	}
+	i := s.newIssue(rp, s.caller(r), *req.Title, "")
+	req.Title = nil
+	if !s.applyEdit(w, rp, i, &req) {
+		delete(rp.issues, i.Number)
+		rp.nextNum--
+		return
+	}
+	writeJSON(w, http.StatusCreated, i)
+}
+
+// PATCH /repos/OWNER/REPO/issues/NUM
+func (s *Server) editIssue(w http.ResponseWriter, r *http.Request, rp *repo, i *issue) {
+	var req issueRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	// validate against a copy so that a failed edit changes nothing
+	c := *i
+	if !s.applyEdit(w, rp, &c, &req) {
+		return
+	}
+	*i = c
+	writeJSON(w, http.StatusOK, i)
+}
+
+// Apply the fields of an edit request to an issue.  Writes an error
+// response and returns false if the request is invalid.
+func (s *Server) applyEdit(w http.ResponseWriter, rp *repo, i *issue, req *issueRequest) bool {
+	now := time.Now().UTC()
+	if req.Title != nil {
+		i.Title = *req.Title
+	}
+	if req.Body != nil {
+		i.Body = *req.Body
+	}
+	if req.State != nil {
+		if *req.State != "open" && *req.State != "closed" {
+			writeError(w, http.StatusUnprocessableEntity, "Validation Failed")
+			return false
+		}
+		i.setState(*req.State, now)
+	}
+	var logins []string
+	switch {
+	case req.Assignees != nil:
+		logins = *req.Assignees
+	case req.Assignee != nil && *req.Assignee != "":
+		logins = []string{*req.Assignee}
+	}
+	if req.Assignees != nil || req.Assignee != nil {
+		i.Assignees = []*user{}
+		for _, login := range logins {
+			u, ok := s.assignable(login)
+			if !ok {
+				writeError(w, http.StatusUnprocessableEntity, "Validation Failed")
+				return false
+			}
+			i.Assignees = append(i.Assignees, u)
+		}
+		i.fixAssignee()
+	}
+	if len(req.Milestone) > 0 {
+		var num *int
+		if err := json.Unmarshal(req.Milestone, &num); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "Validation Failed")
+			return false
+		}
+		i.Milestone = nil
+		if num != nil {
+			m, ok := rp.milestones[*num]
+			if !ok {
+				writeError(w, http.StatusUnprocessableEntity, "Validation Failed")
+				return false
+			}
+			i.Milestone = m
+		}
+	}
+	if req.Labels != nil {
+		i.Labels = []*label{}
+		for _, name := range *req.Labels {
+			i.Labels = append(i.Labels, rp.getLabel(name))
+		}
+	}
+	i.UpdatedAt = now
+	return true
+}
+
+// Return the login of the user making a request.  The fake doesn't know
+// who owns a token so everyone is "octocat" unless they say otherwise
+// in the X-Fake-User header.
+func (s *Server) caller(r *http.Request) string {
+	if u := r.Header.Get("X-Fake-User"); u != "" {
+		return u
+	}
+	return "octocat"
+}
+
+// GET /repos/OWNER/REPO/issues/NUM/comments
+func (s *Server) listComments(w http.ResponseWriter, r *http.Request, i *issue) {
+	items := make([]interface{}, len(i.comments))
+	for n, c := range i.comments {
+		items[n] = c
+	}
+	s.writePage(w, r, items, nil)
+}
+
+// POST /repos/OWNER/REPO/issues/NUM/comments
+func (s *Server) createComment(w http.ResponseWriter, r *http.Request, rp *repo, i *issue) {
+	var req struct {
+		Body string `json:"body"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Body == "" {
+		writeError(w, http.StatusUnprocessableEntity, "Validation Failed")
+		return
+	}
+	now := time.Now().UTC()
+	c := s.newComment(rp, i, s.caller(r), req.Body, now)
+	i.UpdatedAt = now
+	writeJSON(w, http.StatusCreated, c)
+}
+
+// Decode a list of label names which github accepts either as a bare
+// array or as {"labels": [...]}.
+func readLabelNames(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var v interface{}
+	if !readJSON(w, r, &v) {
+		return nil, false
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		v = m["labels"]
+	}
+	list, _ := v.([]interface{})
+	var names []string
+	for _, e := range list {
+		name, ok := e.(string)
+		if !ok {
+			writeError(w, http.StatusUnprocessableEntity, "Validation Failed")
+			return nil, false
+		}
+		names = append(names, name)
+	}
+	return names, true
+}
+
+// POST or PUT /repos/OWNER/REPO/issues/NUM/labels
+func (s *Server) addLabels(w http.ResponseWriter, r *http.Request, rp *repo, i *issue, replace bool) {
+	names, ok := readLabelNames(w, r)
+	if !ok {
+		return
+	}
+	if replace {
+		i.Labels = []*label{}
+	}
+	for _, name := range names {
+		if !i.hasLabel(name) {
+			i.Labels = append(i.Labels, rp.getLabel(name))
+		}
+	}
+	i.UpdatedAt = time.Now().UTC()
+	writeJSON(w, http.StatusOK, i.Labels)
+}
+
+// DELETE /repos/OWNER/REPO/issues/NUM/labels/NAME
+func (s *Server) removeLabel(w http.ResponseWriter, r *http.Request, i *issue, name string) {
+	if !i.hasLabel(name) {
+		writeError(w, http.StatusNotFound, "Label does not exist")
+		return
+	}
+	i.Labels = dropLabel(i.Labels, name)
+	i.UpdatedAt = time.Now().UTC()
+	writeJSON(w, http.StatusOK, i.Labels)
+}
+
+// DELETE /repos/OWNER/REPO/issues/NUM/labels
+func (s *Server) clearLabels(w http.ResponseWriter, r *http.Request, i *issue) {
+	i.Labels = []*label{}
+	i.UpdatedAt = time.Now().UTC()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST or DELETE /repos/OWNER/REPO/issues/NUM/assignees
+func (s *Server) changeAssignees(w http.ResponseWriter, r *http.Request, i *issue, add bool) {
+	var req struct {
+		Assignees []string `json:"assignees"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	for _, login := range req.Assignees {
+		if add {
+			// github silently ignores users that can't be assigned
+			if u, ok := s.assignable(login); ok && !i.assignedTo(login) {
+				i.Assignees = append(i.Assignees, u)
+			}
+			continue
+		}
+		var keep []*user
+		for _, u := range i.Assignees {
+			if !strings.EqualFold(u.Login, login) {
+				keep = append(keep, u)
+			}
+		}
+		i.Assignees = keep
+	}
+	i.fixAssignee()
+	i.UpdatedAt = time.Now().UTC()
+	status := http.StatusOK
+	if add {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, i)
+}
+
+// GET /repos/OWNER/REPO/labels
+func (s *Server) listLabels(w http.ResponseWriter, r *http.Request, rp *repo) {
+	var names []string
+	for k := range rp.labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	items := make([]interface{}, len(names))
+	for n, k := range names {
+		items[n] = rp.labels[k]
+	}
+	s.writePage(w, r, items, nil)
+}
+
+// POST /repos/OWNER/REPO/labels
+func (s *Server) createLabel(w http.ResponseWriter, r *http.Request, rp *repo) {
+	var req struct {
+		Name  string `json:"name"`
+		Color string `json:"color"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if _, ok := rp.labels[strings.ToLower(req.Name)]; ok || req.Name == "" {
+		writeError(w, http.StatusUnprocessableEntity, "Validation Failed")
+		return
+	}
+	l := rp.getLabel(req.Name)
+	if req.Color != "" {
+		l.Color = req.Color
+	}
+	writeJSON(w, http.StatusCreated, l)
+}
+
+// GET /repos/OWNER/REPO/milestones
+func (s *Server) listMilestones(w http.ResponseWriter, r *http.Request, rp *repo) {
+	state := r.URL.Query().Get("state")
+	if state == "" {
+		state = "open"
+	}
+	var items []interface{}
+	for n := 1; n <= len(rp.milestones); n++ {
+		m := rp.milestones[n]
+		if state == "all" || m.State == state {
+			items = append(items, m)
+		}
+	}
+	s.writePage(w, r, items, nil)
+}
+
+// GET /repos/OWNER/REPO/assignees
+func (s *Server) listAssignees(w http.ResponseWriter, r *http.Request) {
+	var logins []string
+	for k := range s.assignees {
+		logins = append(logins, k)
+	}
+	sort.Strings(logins)
+	items := make([]interface{}, len(logins))
+	for n, k := range logins {
+		items[n] = s.assignees[k]
+	}
+	s.writePage(w, r, items, nil)
+}
+
+// GET /repos/OWNER/REPO/collaborators/LOGIN/permission
+func (s *Server) getPermission(w http.ResponseWriter, r *http.Request, rp *repo, login string) {
+	u, ok := s.users[strings.ToLower(login)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	role, ok := rp.perms[strings.ToLower(login)]
+	if !ok {
+		role = "read"
+	}
+	// the legacy "permission" field folds maintain into write and
+	// triage into read
+	perm := role
+	switch role {
+	case "maintain":
+		perm = "write"
+	case "triage":
+		perm = "read"
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"permission": perm, "role_name": role, "user": u})
+}
+
+// GET /users/LOGIN/gists
+func (s *Server) listGists(w http.ResponseWriter, r *http.Request, u *user) {
+	var items []interface{}
+	for n := len(s.gists) - 1; n >= 0; n-- {
+		if g := s.gists[n]; g.Owner == u {
+			items = append(items, g.summary())
+		}
+	}
+	s.writePage(w, r, items, nil)
+}
+
+// GET /gists/ID
+func (s *Server) getGist(w http.ResponseWriter, r *http.Request, id string) {
+	for _, g := range s.gists {
+		if g.ID == id {
+			writeJSON(w, http.StatusOK, g)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "Not Found")
+}
+
+// Write one page of a list response with github style Link headers.  If
+// wrap is not nil the page is passed through it before being written.
+// (e.g. to build a search response)
+func (s *Server) writePage(w http.ResponseWriter, r *http.Request, items []interface{}, wrap func([]interface{}) interface{}) {
+	q := r.URL.Query()
+	perPage := s.perPage
+	if v, err := strconv.Atoi(q.Get("per_page")); err == nil && v > 0 {
+		perPage = v
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+	page := 1
+	if v, err := strconv.Atoi(q.Get("page")); err == nil && v > 0 {
+		page = v
+	}
+	last := (len(items) + perPage - 1) / perPage
+	if last == 0 {
+		last = 1
+	}
+
+	start := (page - 1) * perPage
+	end := start + perPage
+	if start > len(items) {
+		start = len(items)
+	}
+	if end > len(items) {
+		end = len(items)
+	}
+
+	var links []string
+	link := func(p int, rel string) {
+		q.Set("page", strconv.Itoa(p))
+		u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+		links = append(links, fmt.Sprintf(`<%s%s>; rel="%s"`, s.URL, u.RequestURI(), rel))
+	}
+	if page < last {
+		link(page+1, "next")
+		link(last, "last")
+	}
+	if page > 1 {
+		link(1, "first")
+		link(page-1, "prev")
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	out := items[start:end]
+	if wrap != nil {
+		writeJSON(w, http.StatusOK, wrap(out))
+	} else {
+		writeJSON(w, http.StatusOK, out)
+	}
+}
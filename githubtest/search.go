@@ -0,0 +1,179 @@
+package githubtest
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A single search qualifier or free text term
+type searchTerm struct {
+	key   string
+	value string
+}
+
+// GET /search/issues
+//
+// Supports the qualifiers repo:, is:open, is:closed, is:issue, is:pr, state:,
+// label:, assignee:, author:, milestone:, no:assignee, no:label,
+// no:milestone, created: and
+// updated: (with <, <=, > and >= dates) along with free text that
+// matches the title or body.  Terms may be negated with a leading '-'.
+func (s *Server) searchIssues(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	terms := splitSearch(q.Get("q"))
+	if len(terms) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "Validation Failed")
+		return
+	}
+
+	var issues []*issue
+	for name, rp := range s.repos {
+		for _, i := range rp.issues {
+			if matchSearch(name, i, terms) {
+				issues = append(issues, i)
+			}
+		}
+	}
+	by := q.Get("sort")
+	if by == "" {
+		by = "created"
+	}
+	sortIssues(issues, by, q.Get("order"))
+
+	items := make([]interface{}, len(issues))
+	for n, i := range issues {
+		items[n] = i
+	}
+	s.writePage(w, r, items, func(page []interface{}) interface{} {
+		return map[string]interface{}{
+			"total_count":        len(items),
+			"incomplete_results": false,
+			"items":              page,
+		}
+	})
+}
+
+// Split a search query into qualifiers and terms honoring double quotes.
+func splitSearch(q string) []searchTerm {
+	var terms []searchTerm
+	var cur []byte
+	quoted := false
+	flush := func() {
+		if len(cur) == 0 {
+			return
+		}
+		t := string(cur)
+		cur = cur[:0]
+		if k := strings.IndexByte(t, ':'); k > 0 {
+			terms = append(terms, searchTerm{strings.ToLower(t[:k]), t[k+1:]})
+		} else {
+			terms = append(terms, searchTerm{"", t})
+		}
+	}
+	for i := 0; i < len(q); i++ {
+		switch c := q[i]; {
+		case c == '"':
+			quoted = !quoted
+		case c == ' ' && !quoted:
+			flush()
+		default:
+			cur = append(cur, c)
+		}
+	}
+	flush()
+	return terms
+}
+
+func matchSearch(repo string, i *issue, terms []searchTerm) bool {
+	for _, t := range terms {
+		neg := false
+		key := t.key
+		if strings.HasPrefix(key, "-") {
+			neg, key = true, key[1:]
+		} else if key == "" && strings.HasPrefix(t.value, "-") {
+			neg = true
+			t.value = t.value[1:]
+		}
+		if matchTerm(repo, i, key, t.value) == neg {
+			return false
+		}
+	}
+	return true
+}
+
+func matchTerm(repo string, i *issue, key string, value string) bool {
+	switch key {
+	case "":
+		v := strings.ToLower(value)
+		return strings.Contains(strings.ToLower(i.Title), v) ||
+			strings.Contains(strings.ToLower(i.Body), v)
+	case "repo":
+		return strings.EqualFold(repo, value)
+	case "is", "state", "type":
+		switch value {
+		case "open", "closed":
+			return i.State == value
+		case "issue":
+			return i.PullRequest == nil
+		case "pr":
+			return i.PullRequest != nil
+		}
+		return false
+	case "label":
+		return i.hasLabel(value)
+	case "assignee":
+		return i.assignedTo(value)
+	case "author":
+		return strings.EqualFold(i.User.Login, value)
+	case "milestone":
+		return i.Milestone != nil && strings.EqualFold(i.Milestone.Title, value)
+	case "no":
+		switch value {
+		case "assignee":
+			return len(i.Assignees) == 0
+		case "label":
+			return len(i.Labels) == 0
+		case "milestone":
+			return i.Milestone == nil
+		}
+		return false
+	case "created":
+		return matchDate(i.CreatedAt, value)
+	case "updated":
+		return matchDate(i.UpdatedAt, value)
+	}
+	return false
+}
+
+// Compare a time against a search date qualifier such as "<2018-01-02"
+// or ">=2018-01-02T15:04:05Z".  A bare date matches that whole day.
+func matchDate(t time.Time, q string) bool {
+	op := ""
+	for _, p := range []string{"<=", ">=", "<", ">"} {
+		if strings.HasPrefix(q, p) {
+			op, q = p, q[len(p):]
+			break
+		}
+	}
+	// [d, end) is the range of times that the date covers
+	d, err := time.Parse(time.RFC3339, q)
+	end := d.Add(time.Second)
+	if err != nil {
+		if d, err = time.Parse("2006-01-02", q); err != nil {
+			return false
+		}
+		end = d.Add(24 * time.Hour)
+	}
+	switch op {
+	case "<":
+		return t.Before(d)
+	case "<=":
+		return t.Before(end)
+	case ">":
+		return !t.Before(end)
+	case ">=":
+		return !t.Before(d)
+	}
+	return !t.Before(d) && t.Before(end)
+}
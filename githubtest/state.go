@@ -0,0 +1,267 @@
+package githubtest
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The wire formats of github objects.  These carry the same JSON field
+// names as the real github API rather than reusing the github package's
+// types so that the fake exercises the client's decoding.
+
+type user struct {
+	Login   string `json:"login"`
+	ID      int    `json:"id"`
+	HTMLURL string `json:"html_url"`
+}
+
+// A user's full profile as returned by GET /users/LOGIN
+type profile struct {
+	*user
+	Bio string `json:"bio"`
+}
+
+type gist struct {
+	ID          string               `json:"id"`
+	Description string               `json:"description"`
+	HTMLURL     string               `json:"html_url"`
+	Public      bool                 `json:"public"`
+	Owner       *user                `json:"owner"`
+	Files       map[string]*gistFile `json:"files"`
+	CreatedAt   time.Time            `json:"created_at"`
+}
+
+type gistFile struct {
+	Filename string `json:"filename"`
+	Size     int    `json:"size"`
+	Content  string `json:"content,omitempty"`
+}
+
+type label struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+	URL   string `json:"url"`
+}
+
+type milestone struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+}
+
+type comment struct {
+	ID        int       `json:"id"`
+	HTMLURL   string    `json:"html_url"`
+	User      *user     `json:"user"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type issue struct {
+	Number    int        `json:"number"`
+	ID        int        `json:"id"`
+	Title     string     `json:"title"`
+	HTMLURL   string     `json:"html_url"`
+	State     string     `json:"state"`
+	User      *user      `json:"user"`
+	Assignee  *user      `json:"assignee"`
+	Assignees []*user    `json:"assignees"`
+	Body      string     `json:"body"`
+	Comments  int        `json:"comments"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ClosedAt  *time.Time `json:"closed_at"`
+	Labels    []*label   `json:"labels"`
+	Milestone *milestone `json:"milestone"`
+	Locked    bool       `json:"locked"`
+
+	// only set on pull requests, which github also lists as issues
+	PullRequest *pullRequest `json:"pull_request,omitempty"`
+
+	comments []*comment
+}
+
+type pullRequest struct {
+	URL     string `json:"url"`
+	HTMLURL string `json:"html_url"`
+}
+
+// A repository and its issues, labels and milestones
+type repo struct {
+	name       string
+	issues     map[int]*issue
+	labels     map[string]*label
+	milestones map[int]*milestone
+	perms      map[string]string
+	nextNum    int
+}
+
+// Github keeps "assignee" as the first of "assignees".
+func (i *issue) fixAssignee() {
+	if len(i.Assignees) > 0 {
+		i.Assignee = i.Assignees[0]
+	} else {
+		i.Assignee = nil
+		i.Assignees = []*user{}
+	}
+}
+
+func (i *issue) hasLabel(name string) bool {
+	for _, l := range i.Labels {
+		if strings.EqualFold(l.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *issue) assignedTo(login string) bool {
+	for _, u := range i.Assignees {
+		if strings.EqualFold(u.Login, login) {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *issue) setState(state string, now time.Time) {
+	if state == i.State {
+		return
+	}
+	i.State = state
+	if state == "closed" {
+		i.ClosedAt = &now
+	} else {
+		i.ClosedAt = nil
+	}
+}
+
+// Return a repo creating it if it doesn't exist.  Must hold the lock.
+func (s *Server) getRepo(name string) *repo {
+	rp, ok := s.repos[name]
+	if !ok {
+		rp = &repo{
+			name:       name,
+			issues:     make(map[int]*issue),
+			labels:     make(map[string]*label),
+			milestones: make(map[int]*milestone),
+			perms:      make(map[string]string),
+			nextNum:    1,
+		}
+		s.repos[name] = rp
+	}
+	return rp
+}
+
+// Return a user creating it if it doesn't exist.  Must hold the lock.
+func (s *Server) getUser(login string) *user {
+	u, ok := s.users[strings.ToLower(login)]
+	if !ok {
+		u = &user{
+			Login:   login,
+			ID:      s.newID(),
+			HTMLURL: "https://github.com/" + login,
+		}
+		s.users[strings.ToLower(login)] = u
+	}
+	return u
+}
+
+// Look up a user that may be assigned to issues.  If no users have been
+// registered any user is allowed.  Must hold the lock.
+func (s *Server) assignable(login string) (*user, bool) {
+	if u, ok := s.assignees[strings.ToLower(login)]; ok {
+		return u, true
+	}
+	if len(s.assignees) > 0 {
+		return nil, false
+	}
+	return s.getUser(login), true
+}
+
+// Return a copy of a gist without the content of its files as gist
+// listings do.
+func (g *gist) summary() *gist {
+	c := *g
+	c.Files = make(map[string]*gistFile)
+	for name, f := range g.Files {
+		c.Files[name] = &gistFile{Filename: f.Filename, Size: f.Size}
+	}
+	return &c
+}
+
+func (s *Server) newID() int {
+	s.nextID++
+	return s.nextID
+}
+
+// Create a new open issue.  Must hold the lock.
+func (s *Server) newIssue(rp *repo, author, title, body string) *issue {
+	now := time.Now().UTC()
+	i := &issue{
+		Number:    rp.nextNum,
+		ID:        s.newID(),
+		Title:     title,
+		Body:      body,
+		State:     "open",
+		User:      s.getUser(author),
+		Assignees: []*user{},
+		Labels:    []*label{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	i.HTMLURL = fmt.Sprintf("https://github.com/%s/issues/%d", rp.name, i.Number)
+	rp.issues[i.Number] = i
+	rp.nextNum++
+	return i
+}
+
+// Create a new comment on an issue.  Must hold the lock.
+func (s *Server) newComment(rp *repo, i *issue, login, body string, when time.Time) *comment {
+	c := &comment{
+		ID:        s.newID(),
+		User:      s.getUser(login),
+		Body:      body,
+		CreatedAt: when,
+		UpdatedAt: when,
+	}
+	c.HTMLURL = fmt.Sprintf("%s#issuecomment-%d", i.HTMLURL, c.ID)
+	i.comments = append(i.comments, c)
+	i.Comments = len(i.comments)
+	return c
+}
+
+// Return a label creating it if it doesn't exist.
+func (rp *repo) getLabel(name string) *label {
+	l, ok := rp.labels[strings.ToLower(name)]
+	if !ok {
+		l = &label{
+			Name:  name,
+			Color: "ededed",
+			URL:   "https://api.github.com/repos/" + rp.name + "/labels/" + url.PathEscape(name),
+		}
+		rp.labels[strings.ToLower(name)] = l
+	}
+	return l
+}
+
+// Delete a label from the repo and every issue in it.
+func (rp *repo) deleteLabel(l *label) {
+	delete(rp.labels, strings.ToLower(l.Name))
+	for _, i := range rp.issues {
+		i.Labels = dropLabel(i.Labels, l.Name)
+	}
+}
+
+func dropLabel(labels []*label, name string) []*label {
+	out := []*label{}
+	for _, l := range labels {
+		if !strings.EqualFold(l.Name, name) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
@@ -0,0 +1,315 @@
+// An in-memory inverted index over github issues supporting stemmed term
+// queries, phrase queries and simple field filters.  Results are ranked
+// with BM25 and weighted by how recently the issue was updated.
+package index
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Fields of a document that are searchable as text.
+const (
+	Title = iota
+	Body
+	Comments
+	Labels
+	numFields
+)
+
+// Relative weight of a term occurrence in each field.
+var fieldWeight = [numFields]float64{
+	Title:    2.5,
+	Body:     1.0,
+	Comments: 0.8,
+	Labels:   1.5,
+}
+
+// Tuning parameters for the ranking function.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	// Recently updated issues get up to this fraction added to their score
+	recencyBoost = 0.5
+	// The recency boost halves every recencyHalfLife
+	recencyHalfLife = 90 * 24 * time.Hour
+)
+
+// Doc is a single indexable document.  Text holds the raw text for each
+// of the searchable fields.  The remaining fields are used for filtering
+// and ranking.
+type Doc struct {
+	ID        int
+	Text      [numFields]string
+	State     string
+	Assignees []string
+	Labels    []string
+	UpdatedAt time.Time
+}
+
+// Result is a single ranked query result.
+type Result struct {
+	ID    int
+	Score float64
+}
+
+// Identifies one field of one document.
+type fieldKey struct {
+	doc   int
+	field int
+}
+
+// Positions of a term within one field of one document.
+type posting struct {
+	doc   int
+	field int
+	pos   []int
+}
+
+// Index is an inverted index of documents.  It is not safe for concurrent
+// use:  callers must provide their own locking.
+type Index struct {
+	docs     map[int]*Doc
+	lengths  map[int]float64
+	postings map[string][]*posting
+	totalLen float64
+	now      func() time.Time
+}
+
+// Create a new empty index.
+func New() *Index {
+	return &Index{
+		docs:     make(map[int]*Doc),
+		lengths:  make(map[int]float64),
+		postings: make(map[string][]*posting),
+		now:      time.Now,
+	}
+}
+
+// Return the number of documents in the index.
+func (x *Index) Len() int {
+	return len(x.docs)
+}
+
+// Add a document to the index replacing any existing document with the
+// same ID.
+func (x *Index) Add(d *Doc) {
+	x.Remove(d.ID)
+	x.docs[d.ID] = d
+	dlen := 0.0
+	for f := 0; f < numFields; f++ {
+		terms := Tokenize(d.Text[f])
+		dlen += fieldWeight[f] * float64(len(terms))
+		byTerm := make(map[string]*posting)
+		for i, t := range terms {
+			p, ok := byTerm[t]
+			if !ok {
+				p = &posting{doc: d.ID, field: f}
+				byTerm[t] = p
+				x.postings[t] = append(x.postings[t], p)
+			}
+			p.pos = append(p.pos, i)
+		}
+	}
+	x.lengths[d.ID] = dlen
+	x.totalLen += dlen
+}
+
+// Remove a document from the index if present.
+func (x *Index) Remove(id int) {
+	d, ok := x.docs[id]
+	if !ok {
+		return
+	}
+	for f := 0; f < numFields; f++ {
+		for _, t := range Tokenize(d.Text[f]) {
+			x.postings[t] = dropDoc(x.postings[t], id)
+			if len(x.postings[t]) == 0 {
+				delete(x.postings, t)
+			}
+		}
+	}
+	x.totalLen -= x.lengths[id]
+	delete(x.lengths, id)
+	delete(x.docs, id)
+}
+
+func dropDoc(pl []*posting, id int) []*posting {
+	n := 0
+	for _, p := range pl {
+		if p.doc != id {
+			pl[n] = p
+			n++
+		}
+	}
+	return pl[:n]
+}
+
+// Split text into lower case, stemmed terms.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = Stem(w)
+	}
+	return words
+}
+
+// Run a query (see Parse()) against the index and return at most max
+// results in order of decreasing relevance.  If max <= 0 all matching
+// results are returned.
+func (x *Index) Search(query string, max int) []Result {
+	return x.SearchQuery(Parse(query), max)
+}
+
+// Run a pre-parsed query against the index.
+func (x *Index) SearchQuery(q *Query, max int) []Result {
+	var results []Result
+	for id, score := range x.candidates(q) {
+		d := x.docs[id]
+		if !q.matchFilters(d) {
+			continue
+		}
+		results = append(results, Result{id, score * x.recency(d)})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID > results[j].ID
+	})
+	if max > 0 && len(results) > max {
+		results = results[:max]
+	}
+	return results
+}
+
+// Return the set of documents that match all of the terms and phrases in
+// the query along with their BM25 score.  A query with no terms or
+// phrases matches every document with an equal score so that filter-only
+// queries are ranked by recency.
+func (x *Index) candidates(q *Query) map[int]float64 {
+	scores := make(map[int]float64)
+	if len(q.Terms) == 0 && len(q.Phrases) == 0 {
+		for id := range x.docs {
+			scores[id] = 1
+		}
+		return scores
+	}
+
+	first := true
+	for _, t := range q.Terms {
+		ts := x.termScores(t)
+		scores = merge(scores, ts, first)
+		first = false
+	}
+	for _, ph := range q.Phrases {
+		ps := x.phraseScores(ph)
+		scores = merge(scores, ps, first)
+		first = false
+	}
+	return scores
+}
+
+// Intersect the accumulated scores with a new set, summing the scores.
+func merge(acc, next map[int]float64, first bool) map[int]float64 {
+	if first {
+		return next
+	}
+	out := make(map[int]float64)
+	for id, s := range acc {
+		if ns, ok := next[id]; ok {
+			out[id] = s + ns
+		}
+	}
+	return out
+}
+
+// Compute the BM25 score of a single term for every document it occurs in.
+func (x *Index) termScores(term string) map[int]float64 {
+	tf := make(map[int]float64)
+	for _, p := range x.postings[term] {
+		tf[p.doc] += fieldWeight[p.field] * float64(len(p.pos))
+	}
+	return x.bm25(tf)
+}
+
+// Compute the BM25 score of a phrase treating each occurrence of the
+// phrase as an occurrence of a single term.
+func (x *Index) phraseScores(phrase []string) map[int]float64 {
+	if len(phrase) == 1 {
+		return x.termScores(phrase[0])
+	}
+	// Map each (doc, field) to the positions of each term in the phrase
+	pos := make([]map[fieldKey][]int, len(phrase))
+	for i, t := range phrase {
+		pos[i] = make(map[fieldKey][]int)
+		for _, p := range x.postings[t] {
+			pos[i][fieldKey{p.doc, p.field}] = p.pos
+		}
+	}
+	tf := make(map[int]float64)
+	for k, starts := range pos[0] {
+		n := 0
+		for _, s := range starts {
+			if phraseAt(pos, k, s) {
+				n++
+			}
+		}
+		if n > 0 {
+			tf[k.doc] += fieldWeight[k.field] * float64(n)
+		}
+	}
+	return x.bm25(tf)
+}
+
+// Returns true if term i of the phrase occurs at position start+i for
+// every term in the phrase.
+func phraseAt(pos []map[fieldKey][]int, k fieldKey, start int) bool {
+	for i := 1; i < len(pos); i++ {
+		pl := pos[i][k]
+		j := sort.SearchInts(pl, start+i)
+		if j == len(pl) || pl[j] != start+i {
+			return false
+		}
+	}
+	return true
+}
+
+// Convert weighted term frequencies into BM25 scores.
+func (x *Index) bm25(tf map[int]float64) map[int]float64 {
+	scores := make(map[int]float64)
+	if len(tf) == 0 {
+		return scores
+	}
+	n := float64(len(x.docs))
+	df := float64(len(tf))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+	avg := x.totalLen / n
+	if avg == 0 {
+		avg = 1
+	}
+	for id, f := range tf {
+		norm := bm25K1 * (1 - bm25B + bm25B*x.lengths[id]/avg)
+		scores[id] = idf * f * (bm25K1 + 1) / (f + norm)
+	}
+	return scores
+}
+
+// Return the multiplier to apply to a score based on how recently the
+// document was updated.
+func (x *Index) recency(d *Doc) float64 {
+	if d.UpdatedAt.IsZero() {
+		return 1
+	}
+	age := x.now().Sub(d.UpdatedAt)
+	if age < 0 {
+		age = 0
+	}
+	return 1 + recencyBoost*math.Exp2(-float64(age)/float64(recencyHalfLife))
+}
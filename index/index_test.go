@@ -0,0 +1,188 @@
+package index
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// Build an index over a small set of issues with a fixed clock.
+func testIndex() *Index {
+	x := New()
+	x.now = func() time.Time { return testNow }
+	docs := []*Doc{
+		{
+			ID:        1,
+			Text:      [numFields]string{Title: "Crash when connecting to the server", Body: "The client crashes on connect."},
+			State:     "open",
+			Assignees: []string{"alice"},
+			Labels:    []string{"bug"},
+		},
+		{
+			ID:    2,
+			Text:  [numFields]string{Title: "Document the options", Body: "Describe every server connection option and what it does for the client."},
+			State: "closed",
+		},
+		{
+			ID:        3,
+			Text:      [numFields]string{Title: "Server panics at startup", Body: "panic: nil map", Comments: "seen after a crash too", Labels: "bug help wanted"},
+			State:     "open",
+			Assignees: []string{"Bob"},
+			Labels:    []string{"bug", "help wanted"},
+		},
+		{
+			ID:        4,
+			Text:      [numFields]string{Title: "Dark mode", Body: "Please add a dark mode.  The server should remember the mode."},
+			State:     "open",
+			Assignees: []string{"alice", "bob"},
+			Labels:    []string{"enhancement"},
+		},
+	}
+	for _, d := range docs {
+		x.Add(d)
+	}
+	return x
+}
+
+func ids(results []Result) []int {
+	out := []int{}
+	for _, r := range results {
+		out = append(out, r.ID)
+	}
+	return out
+}
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		word, stem string
+	}{
+		{"caresses", "caress"},
+		{"ponies", "poni"},
+		{"cats", "cat"},
+		{"agreed", "agre"},
+		{"plastered", "plaster"},
+		{"motoring", "motor"},
+		{"sing", "sing"},
+		{"hopping", "hop"},
+		{"filing", "file"},
+		{"happy", "happi"},
+		{"relational", "relat"},
+		{"hopeful", "hope"},
+		{"goodness", "good"},
+		{"connecting", "connect"},
+		{"connection", "connect"},
+		{"connects", "connect"},
+		{"go", "go"},
+		{"ünïcode", "ünïcode"},
+	}
+	for _, tt := range tests {
+		if got := Stem(tt.word); got != tt.stem {
+			t.Errorf("Stem(%q) = %q, want %q", tt.word, got, tt.stem)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  *Query
+	}{
+		{"crashes", &Query{Terms: []string{"crash"}}},
+		{`"server panics" startup`, &Query{Terms: []string{"startup"}, Phrases: [][]string{{"server", "panic"}}}},
+		{"nil-map", &Query{Phrases: [][]string{{"nil", "map"}}}},
+		{`label:"Help Wanted" assignee:Bob state:OPEN`,
+			&Query{Labels: []string{"help wanted"}, Assignees: []string{"bob"}, State: "open"}},
+		// only known fields are filters
+		{"Label:bug title:crash", &Query{Phrases: [][]string{{"titl", "crash"}}, Labels: []string{"bug"}}},
+		{`"unterminated phrase`, &Query{Phrases: [][]string{{"untermin", "phrase"}}}},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []int
+	}{
+		// title matches outweigh body and comment matches
+		{"title first", "crash", []int{1, 3}},
+		{"shorter document first", "server", []int{1, 3, 4, 2}},
+		{"stemmed terms", "connections", []int{1, 2}},
+		{"all terms", "server client", []int{1, 2}},
+		{"no match", "kubernetes", []int{}},
+		{"phrase", `"dark mode"`, []int{4}},
+		{"phrase order", `"mode dark"`, []int{}},
+		{"stemmed phrase", `"server connections"`, []int{2}},
+		{"phrase across fields", `"server panic"`, []int{3}},
+		{"label", "label:bug", []int{3, 1}},
+		{"quoted label", `server label:"help wanted"`, []int{3}},
+		{"label case", "label:BUG crash", []int{1, 3}},
+		{"assignee", "assignee:alice", []int{4, 1}},
+		{"assignee case", "assignee:bob server", []int{3, 4}},
+		{"two assignees", "assignee:alice assignee:bob", []int{4}},
+		{"state", "state:closed", []int{2}},
+		{"state and term", "server state:open", []int{1, 3, 4}},
+		{"filters only", "label:bug state:closed", []int{}},
+	}
+	x := testIndex()
+	for _, tt := range tests {
+		got := ids(x.Search(tt.query, 0))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Search(%q) = %v, want %v", tt.name, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchMax(t *testing.T) {
+	x := testIndex()
+	if got := ids(x.Search("server", 2)); !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Errorf("Search(server, 2) = %v, want [1 3]", got)
+	}
+}
+
+func TestRecency(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new time.Duration // age of docs 1 and 2
+		want     []int
+	}{
+		{"newer first", 200 * 24 * time.Hour, time.Hour, []int{2, 1}},
+		{"older last", time.Hour, 200 * 24 * time.Hour, []int{1, 2}},
+		{"equal ages by ID", time.Hour, time.Hour, []int{2, 1}},
+	}
+	for _, tt := range tests {
+		x := New()
+		x.now = func() time.Time { return testNow }
+		x.Add(&Doc{ID: 1, Text: [numFields]string{Title: "flaky test"}, UpdatedAt: testNow.Add(-tt.old)})
+		x.Add(&Doc{ID: 2, Text: [numFields]string{Title: "flaky test"}, UpdatedAt: testNow.Add(-tt.new)})
+		if got := ids(x.Search("flaky", 0)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	x := testIndex()
+	x.Remove(3)
+	if x.Len() != 3 {
+		t.Errorf("Len() = %d after remove, want 3", x.Len())
+	}
+	if got := ids(x.Search("panic", 0)); len(got) != 0 {
+		t.Errorf("removed document still found: %v", got)
+	}
+	// Replacing a document drops its old terms
+	x.Add(&Doc{ID: 1, Text: [numFields]string{Title: "Flaky test"}, State: "open"})
+	if got := ids(x.Search("crash", 0)); len(got) != 0 {
+		t.Errorf("replaced document found by old text: %v", got)
+	}
+	if got := ids(x.Search("flaky", 0)); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("replaced document not found by new text: %v", got)
+	}
+}
@@ -0,0 +1,144 @@
+package index
+
+import (
+	"strings"
+)
+
+// Query is a parsed search query.  A document matches the query if it
+// contains every term and every phrase and satisfies every filter.
+type Query struct {
+	Terms     []string
+	Phrases   [][]string
+	Labels    []string
+	Assignees []string
+	State     string
+}
+
+// Parse a query string.  The syntax is a whitespace separated list of:
+//   word            - a term that must appear in the issue
+//   "some words"    - a phrase that must appear in the issue
+//   label:NAME      - the issue must have label NAME
+//   assignee:LOGIN  - the issue must be assigned to LOGIN
+//   state:STATE     - the issue must be in state STATE (open or closed)
+//
+// Filter values may be quoted to include spaces (e.g. label:"help wanted").
+// Filters are matched case insensitively.
+func Parse(s string) *Query {
+	q := &Query{}
+	for _, tok := range splitQuery(s) {
+		if tok.field != "" {
+			q.addFilter(tok.field, tok.text)
+			continue
+		}
+		terms := Tokenize(tok.text)
+		switch {
+		case len(terms) == 0:
+		case len(terms) == 1 && !tok.quoted:
+			q.Terms = append(q.Terms, terms[0])
+		default:
+			// quoted text or words like "foo-bar" that tokenize to
+			// multiple terms are treated as phrases
+			q.Phrases = append(q.Phrases, terms)
+		}
+	}
+	return q
+}
+
+func (q *Query) addFilter(field, value string) {
+	value = strings.ToLower(value)
+	switch field {
+	case "label":
+		q.Labels = append(q.Labels, value)
+	case "assignee":
+		q.Assignees = append(q.Assignees, value)
+	case "state":
+		q.State = value
+	}
+}
+
+// Returns true if the document satisfies all of the query's filters.
+func (q *Query) matchFilters(d *Doc) bool {
+	if q.State != "" && !strings.EqualFold(q.State, d.State) {
+		return false
+	}
+	for _, l := range q.Labels {
+		if !containsFold(d.Labels, l) {
+			return false
+		}
+	}
+	for _, a := range q.Assignees {
+		if !containsFold(d.Assignees, a) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(list []string, s string) bool {
+	for _, e := range list {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// A single lexical element of a query.
+type queryToken struct {
+	field  string
+	text   string
+	quoted bool
+}
+
+var filterFields = map[string]bool{
+	"label":    true,
+	"assignee": true,
+	"state":    true,
+}
+
+// Split a query string into tokens honoring double quotes.
+func splitQuery(s string) []queryToken {
+	var toks []queryToken
+	i := 0
+	for i < len(s) {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) {
+			break
+		}
+		tok := queryToken{}
+		if s[i] != '"' {
+			j := i
+			for j < len(s) && !isSpace(s[j]) && s[j] != '"' && s[j] != ':' {
+				j++
+			}
+			if j < len(s) && s[j] == ':' && filterFields[strings.ToLower(s[i:j])] {
+				tok.field = strings.ToLower(s[i:j])
+				i = j + 1
+			}
+		}
+		if i < len(s) && s[i] == '"' {
+			j := strings.IndexByte(s[i+1:], '"')
+			if j < 0 {
+				j = len(s) - i - 1
+			}
+			tok.text = s[i+1 : i+1+j]
+			tok.quoted = true
+			i += j + 2
+		} else {
+			j := i
+			for j < len(s) && !isSpace(s[j]) {
+				j++
+			}
+			tok.text = s[i:j]
+			i = j
+		}
+		toks = append(toks, tok)
+	}
+	return toks
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
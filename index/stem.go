@@ -0,0 +1,237 @@
+package index
+
+import (
+	"strings"
+)
+
+// This file implements the Porter stemming algorithm as described in:
+//   M.F. Porter, "An algorithm for suffix stripping", Program 14(3), 1980
+//
+// The stemmer only operates on lower case ASCII words.  Anything else is
+// returned unchanged.
+
+// Reduce a lower case word to its stem.
+func Stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+	s := &stemmer{b: []byte(word)}
+	s.step1a()
+	s.step1b()
+	s.step1c()
+	s.step2()
+	s.step3()
+	s.step4()
+	s.step5()
+	return string(s.b)
+}
+
+type stemmer struct {
+	b []byte
+}
+
+// Returns true if b[i] is a consonant.
+func (s *stemmer) cons(i int) bool {
+	switch s.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !s.cons(i-1)
+	}
+	return true
+}
+
+// Measure the number of VC sequences in b[0:n].
+func (s *stemmer) measure(n int) int {
+	m := 0
+	i := 0
+	for i < n && s.cons(i) {
+		i++
+	}
+	for i < n {
+		for i < n && !s.cons(i) {
+			i++
+		}
+		if i >= n {
+			break
+		}
+		for i < n && s.cons(i) {
+			i++
+		}
+		m++
+	}
+	return m
+}
+
+// Returns true if b[0:n] contains a vowel.
+func (s *stemmer) hasVowel(n int) bool {
+	for i := 0; i < n; i++ {
+		if !s.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if b[0:n] ends in a double consonant.
+func (s *stemmer) doubleCons(n int) bool {
+	if n < 2 || s.b[n-1] != s.b[n-2] {
+		return false
+	}
+	return s.cons(n - 1)
+}
+
+// Returns true if b[0:n] ends consonant-vowel-consonant and the final
+// consonant is not w, x or y.
+func (s *stemmer) cvc(n int) bool {
+	if n < 3 || !s.cons(n-1) || s.cons(n-2) || !s.cons(n-3) {
+		return false
+	}
+	switch s.b[n-1] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+func (s *stemmer) ends(suffix string) bool {
+	return strings.HasSuffix(string(s.b), suffix)
+}
+
+// Replace the suffix with repl if the remaining stem has a measure
+// greater than m.  Returns true if the suffix matched regardless of
+// whether the replacement happened.
+func (s *stemmer) replace(suffix, repl string, m int) bool {
+	if !s.ends(suffix) {
+		return false
+	}
+	n := len(s.b) - len(suffix)
+	if s.measure(n) > m {
+		s.b = append(s.b[:n], repl...)
+	}
+	return true
+}
+
+func (s *stemmer) step1a() {
+	switch {
+	case s.ends("sses"):
+		s.b = s.b[:len(s.b)-2]
+	case s.ends("ies"):
+		s.b = s.b[:len(s.b)-2]
+	case s.ends("ss"):
+	case s.ends("s"):
+		s.b = s.b[:len(s.b)-1]
+	}
+}
+
+func (s *stemmer) step1b() {
+	if s.ends("eed") {
+		if s.measure(len(s.b)-3) > 0 {
+			s.b = s.b[:len(s.b)-1]
+		}
+		return
+	}
+	var n int
+	switch {
+	case s.ends("ed"):
+		n = len(s.b) - 2
+	case s.ends("ing"):
+		n = len(s.b) - 3
+	default:
+		return
+	}
+	if !s.hasVowel(n) {
+		return
+	}
+	s.b = s.b[:n]
+	switch {
+	case s.ends("at"), s.ends("bl"), s.ends("iz"):
+		s.b = append(s.b, 'e')
+	case s.doubleCons(n):
+		switch s.b[n-1] {
+		case 'l', 's', 'z':
+		default:
+			s.b = s.b[:n-1]
+		}
+	case s.measure(n) == 1 && s.cvc(n):
+		s.b = append(s.b, 'e')
+	}
+}
+
+func (s *stemmer) step1c() {
+	n := len(s.b) - 1
+	if s.b[n] == 'y' && s.hasVowel(n) {
+		s.b[n] = 'i'
+	}
+}
+
+var step2Rules = [][2]string{
+	{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"},
+	{"anci", "ance"}, {"izer", "ize"}, {"bli", "ble"}, {"alli", "al"},
+	{"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"},
+	{"ization", "ize"}, {"ation", "ate"}, {"ator", "ate"},
+	{"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"},
+	{"ousness", "ous"}, {"aliti", "al"}, {"iviti", "ive"},
+	{"biliti", "ble"}, {"logi", "log"},
+}
+
+func (s *stemmer) step2() {
+	for _, r := range step2Rules {
+		if s.replace(r[0], r[1], 0) {
+			return
+		}
+	}
+}
+
+var step3Rules = [][2]string{
+	{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"},
+	{"ical", "ic"}, {"ful", ""}, {"ness", ""},
+}
+
+func (s *stemmer) step3() {
+	for _, r := range step3Rules {
+		if s.replace(r[0], r[1], 0) {
+			return
+		}
+	}
+}
+
+var step4Suffixes = []string{
+	"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement",
+	"ment", "ent", "ion", "ou", "ism", "ate", "iti", "ous", "ive", "ize",
+}
+
+func (s *stemmer) step4() {
+	for _, suffix := range step4Suffixes {
+		if !s.ends(suffix) {
+			continue
+		}
+		n := len(s.b) - len(suffix)
+		if suffix == "ion" && (n == 0 || (s.b[n-1] != 's' && s.b[n-1] != 't')) {
+			return
+		}
+		if s.measure(n) > 1 {
+			s.b = s.b[:n]
+		}
+		return
+	}
+}
+
+func (s *stemmer) step5() {
+	n := len(s.b)
+	if s.b[n-1] == 'e' {
+		m := s.measure(n - 1)
+		if m > 1 || (m == 1 && !s.cvc(n-1)) {
+			s.b = s.b[:n-1]
+			n--
+		}
+	}
+	if s.b[n-1] == 'l' && s.doubleCons(n) && s.measure(n) > 1 {
+		s.b = s.b[:n-1]
+	}
+}
@@ -8,18 +8,35 @@ import (
 	"os"
 	"strconv"
 
+	"github.com/ctelfer-docker/slkiss/audit"
+	"github.com/ctelfer-docker/slkiss/github"
 	"github.com/ctelfer-docker/slkiss/slack"
+	"github.com/ctelfer-docker/slkiss/store"
 	"github.com/sirupsen/logrus"
 )
 
 // Environment variables
 const (
-	repoEnv = "ISSUEBOT_REPO"     // Github repository to manage
-	userEnv = "ISSUEBOT_USER"     // Github user to access the repo
-	authEnv = "ISSUEBOT_AUTH"     // Authentication token for Github
-	addrEnv = "ISSUEBOT_LADDR"    // Local address to bind to for slack ops 
-	portEnv = "ISSUEBOT_LPORT"    // Local port to bind to for slack ops
-	logEnv  = "ISSUEBOT_LOGLEVEL" // Log level to run at
+	repoEnv  = "ISSUEBOT_REPO"           // Github repository to manage
+	userEnv  = "ISSUEBOT_USER"           // Github user to access the repo
+	authEnv  = "ISSUEBOT_AUTH"           // Authentication token for Github
+	addrEnv  = "ISSUEBOT_LADDR"          // Local address to bind to for slack ops
+	portEnv  = "ISSUEBOT_LPORT"          // Local port to bind to for slack ops
+	logEnv   = "ISSUEBOT_LOGLEVEL"       // Log level to run at
+	mirEnv   = "ISSUEBOT_MIRROR"         // File to keep the local issue mirror in
+	apiEnv   = "ISSUEBOT_GITHUB"         // Root URL of the Github API
+	cfgEnv   = "ISSUEBOT_CONFIG"         // Config file to load
+	sigEnv   = "ISSUEBOT_SIGNING_SECRET" // Slack app signing secret
+	vtokEnv  = "ISSUEBOT_VERIFY_TOKEN"   // Slack verification token (legacy)
+	cidEnv   = "ISSUEBOT_CLIENT_ID"      // Slack app OAuth client ID
+	csecEnv  = "ISSUEBOT_CLIENT_SECRET"  // Slack app OAuth client secret
+	redirEnv = "ISSUEBOT_REDIRECT_URL"   // Slack app OAuth redirect URL
+	wsEnv    = "ISSUEBOT_WORKSPACES"     // File to save workspace tokens in
+	botEnv   = "ISSUEBOT_BOT_TOKEN"      // Slack bot token (without OAuth)
+	appEnv   = "ISSUEBOT_APP_TOKEN"      // Slack app-level token for Socket Mode
+	stateEnv = "ISSUEBOT_STATE"          // File to keep bot state in
+	audEnv   = "ISSUEBOT_AUDIT"          // File to append the audit log to
+	atokEnv  = "ISSUEBOT_AUDIT_TOKEN"    // Token to download the audit log
 )
 
 // Name so that *Level will implement flag.Value type
@@ -41,11 +58,25 @@ func (l *Level) Set(level string) error {
 }
 
 // CLI argumetnts
-var repo  = flag.String("r", "", "Default repository to manage")
-var user  = flag.String("u", "", "Github user for the bot to operate as")
-var auth  = flag.String("a", "", "Authentication token")
-var addr  = flag.String("l", "", "Address to listen on")
-var port  = flag.Uint("p", 80, "Port to listen on")
+var repo   = flag.String("r", "", "Default repository to manage")
+var user   = flag.String("u", "", "Github user for the bot to operate as")
+var auth   = flag.String("a", "", "Authentication token")
+var addr   = flag.String("l", "", "Address to listen on")
+var port   = flag.Uint("p", 80, "Port to listen on")
+var mirfn  = flag.String("m", "", "File to save the issue mirror in")
+var api    = flag.String("g", github.APIRoot, "Root URL of the Github API")
+var cfgfn  = flag.String("c", "", "Config file to load")
+var secret = flag.String("s", "", "Slack signing secret")
+var vtoken = flag.String("t", "", "Slack verification token (if no signing secret)")
+var cid    = flag.String("i", "", "Slack OAuth client ID")
+var csec   = flag.String("k", "", "Slack OAuth client secret")
+var redir  = flag.String("o", "", "Slack OAuth redirect URL")
+var wsfn   = flag.String("w", "", "File to save slack workspace tokens in")
+var bottok = flag.String("b", "", "Slack bot token (if not installed with OAuth)")
+var apptok = flag.String("A", "", "Slack app-level token (to use Socket Mode)")
+var stfn   = flag.String("d", "", "File to keep registered users and other bot state in")
+var audfn  = flag.String("j", "", "File to append the audit log to")
+var audtok = flag.String("J", "", "Bearer token to download the audit log from /audit")
 var logLevel = Level(logrus.InfoLevel)
 
 func init() {
@@ -61,7 +92,46 @@ func main() {
 	logrus.SetLevel(logrus.Level(logLevel))
 	astr := fmt.Sprintf("%s:%d", *addr, *port)
 	bot := slack.NewIssueBot(astr, *repo)
+	bot.SetGithubURL(*api)
 	bot.SetGithubAuth(encodeBasicAuth(*user, *auth))
+	bot.SetSigningSecret(*secret)
+	bot.SetVerificationToken(*vtoken)
+	bot.SetBotToken(*bottok)
+	bot.SetAppToken(*apptok)
+	if *stfn != "" {
+		st, err := store.Open(*stfn)
+		if err != nil {
+			logrus.Fatal("Error opening state file:", err)
+		}
+		if err = bot.SetStore(st); err != nil {
+			logrus.Fatal("Error loading state:", err)
+		}
+	}
+	if *audfn != "" {
+		l, err := audit.Open(*audfn)
+		if err != nil {
+			logrus.Fatal("Error opening audit log:", err)
+		}
+		bot.SetAuditLog(l)
+	}
+	bot.SetAuditToken(*audtok)
+	if *cfgfn != "" {
+		cfg, err := slack.LoadConfig(*cfgfn)
+		if err != nil {
+			logrus.Fatal("Error loading config:", err)
+		}
+		if err = bot.SetConfig(cfg); err != nil {
+			logrus.Fatal("Error in config:", err)
+		}
+	}
+	if *cid != "" {
+		if err := bot.EnableOAuth(*cid, *csec, *redir, *wsfn); err != nil {
+			logrus.Fatal("Error enabling OAuth:", err)
+		}
+	}
+	if err := bot.EnableMirror(*mirfn); err != nil {
+		logrus.Fatal("Error loading issue mirror:", err)
+	}
 	logrus.Info("Starting bot on", astr)
 	bot.Run()
 }
@@ -71,6 +141,20 @@ func getEnv() {
 	if s, ok := os.LookupEnv(userEnv); ok { *user = s }
 	if s, ok := os.LookupEnv(authEnv); ok { *auth = s }
 	if s, ok := os.LookupEnv(addrEnv); ok { *addr = s }
+	if s, ok := os.LookupEnv(mirEnv); ok { *mirfn = s }
+	if s, ok := os.LookupEnv(apiEnv); ok { *api = s }
+	if s, ok := os.LookupEnv(cfgEnv); ok { *cfgfn = s }
+	if s, ok := os.LookupEnv(sigEnv); ok { *secret = s }
+	if s, ok := os.LookupEnv(vtokEnv); ok { *vtoken = s }
+	if s, ok := os.LookupEnv(cidEnv); ok { *cid = s }
+	if s, ok := os.LookupEnv(csecEnv); ok { *csec = s }
+	if s, ok := os.LookupEnv(redirEnv); ok { *redir = s }
+	if s, ok := os.LookupEnv(wsEnv); ok { *wsfn = s }
+	if s, ok := os.LookupEnv(botEnv); ok { *bottok = s }
+	if s, ok := os.LookupEnv(appEnv); ok { *apptok = s }
+	if s, ok := os.LookupEnv(stateEnv); ok { *stfn = s }
+	if s, ok := os.LookupEnv(audEnv); ok { *audfn = s }
+	if s, ok := os.LookupEnv(atokEnv); ok { *audtok = s }
 	if s, ok := os.LookupEnv(portEnv); ok {
 		p, err := strconv.Atoi(s)
 		if err != nil {
@@ -97,6 +181,20 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "\t*   %s - github authentication password\n", authEnv)
 	fmt.Fprintf(os.Stderr, "\t*   %s - local address\n", addrEnv)
 	fmt.Fprintf(os.Stderr, "\t*   %s - local port\n", portEnv)
+	fmt.Fprintf(os.Stderr, "\t*   %s - issue mirror file\n", mirEnv)
+	fmt.Fprintf(os.Stderr, "\t*   %s - github API root URL\n", apiEnv)
+	fmt.Fprintf(os.Stderr, "\t*   %s - config file\n", cfgEnv)
+	fmt.Fprintf(os.Stderr, "\t*   %s - slack signing secret\n", sigEnv)
+	fmt.Fprintf(os.Stderr, "\t*   %s - slack verification token\n", vtokEnv)
+	fmt.Fprintf(os.Stderr, "\t*   %s - slack OAuth client ID\n", cidEnv)
+	fmt.Fprintf(os.Stderr, "\t*   %s - slack OAuth client secret\n", csecEnv)
+	fmt.Fprintf(os.Stderr, "\t*   %s - slack OAuth redirect URL\n", redirEnv)
+	fmt.Fprintf(os.Stderr, "\t*   %s - slack workspace token file\n", wsEnv)
+	fmt.Fprintf(os.Stderr, "\t*   %s - slack bot token\n", botEnv)
+	fmt.Fprintf(os.Stderr, "\t*   %s - slack app-level token\n", appEnv)
+	fmt.Fprintf(os.Stderr, "\t*   %s - bot state file\n", stateEnv)
+	fmt.Fprintf(os.Stderr, "\t*   %s - audit log file\n", audEnv)
+	fmt.Fprintf(os.Stderr, "\t*   %s - audit log download token\n", atokEnv)
 	os.Exit(1)
 }
 
@@ -0,0 +1,274 @@
+// Maintains a local copy of a repository's github issues and comments
+// along with a full-text index over them so that issues can be searched
+// even when github is unreachable or rate limiting us.
+package mirror
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctelfer-docker/slkiss/github"
+	"github.com/ctelfer-docker/slkiss/index"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.WithFields(logrus.Fields{"component": "mirror"})
+
+// Entry is a mirrored issue along with its comments.
+type Entry struct {
+	Issue    *github.Issue
+	Comments []*github.Comment
+}
+
+// The on-disk format of the mirror.
+type snapshot struct {
+	LastSync time.Time
+	Entries  []*Entry
+}
+
+// Mirror holds a local copy of the issues of one repository.
+type Mirror struct {
+	sync.RWMutex
+	agent    *github.Agent
+	path     string
+	lastSync time.Time
+	entries  map[int]*Entry
+	idx      *index.Index
+}
+
+// Create a new mirror that fetches issues using the given agent.  If path
+// is not empty the mirror is loaded from and saved to that file so that
+// its contents survive restarts.
+func New(agent *github.Agent, path string) (*Mirror, error) {
+	m := &Mirror{
+		agent:   agent,
+		path:    path,
+		entries: make(map[int]*Entry),
+		idx:     index.New(),
+	}
+	if path == "" {
+		return m, nil
+	}
+	if err := m.load(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Return the time of the last successful sync with github.
+func (m *Mirror) LastSync() time.Time {
+	m.RLock()
+	defer m.RUnlock()
+	return m.lastSync
+}
+
+// Return the number of mirrored issues.
+func (m *Mirror) Len() int {
+	m.RLock()
+	defer m.RUnlock()
+	return len(m.entries)
+}
+
+// Return a mirrored issue by number.
+func (m *Mirror) Get(num int) (*github.Issue, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	e, ok := m.entries[num]
+	if !ok {
+		return nil, false
+	}
+	return e.Issue, true
+}
+
+// Return the names of all the labels used on mirrored issues, sorted.
+func (m *Mirror) Labels() []string {
+	m.RLock()
+	defer m.RUnlock()
+	seen := make(map[string]bool)
+	var labels []string
+	for _, e := range m.entries {
+		for _, l := range e.Issue.Labels {
+			if !seen[l.Name] {
+				seen[l.Name] = true
+				labels = append(labels, l.Name)
+			}
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// Search the mirrored issues.  See index.Parse() for the query syntax.
+func (m *Mirror) Search(query string, max int) []*github.Issue {
+	m.RLock()
+	defer m.RUnlock()
+	var issues []*github.Issue
+	for _, r := range m.idx.Search(query, max) {
+		issues = append(issues, m.entries[r.ID].Issue)
+	}
+	return issues
+}
+
+// Fetch all issues that have changed since the last sync along with
+// their comments and update the mirror and index.  The github queries are
+// made without holding the mirror lock so searches can proceed during
+// a sync.
+func (m *Mirror) Sync() error {
+	log := log.WithField("method", "Sync")
+	start := time.Now()
+	params := map[string]string{
+		"state":     "all",
+		"sort":      "updated",
+		"direction": "asc",
+		"per_page":  "100",
+	}
+	last := m.LastSync()
+	if !last.IsZero() {
+		params["since"] = last.UTC().Format(time.RFC3339)
+	}
+	issues, err := m.agent.FetchIssues(params)
+	if err != nil {
+		return err
+	}
+
+	updated := make([]*Entry, 0, len(issues))
+	for _, iss := range issues {
+		// The issues API returns pull requests as well
+		if iss.IsPullRequest() {
+			continue
+		}
+		e := &Entry{Issue: iss}
+		if iss.Comments > 0 {
+			e.Comments, err = m.agent.FetchComments(iss.Number)
+			if err != nil {
+				return err
+			}
+		}
+		updated = append(updated, e)
+	}
+
+	m.Lock()
+	for _, e := range updated {
+		m.add(e)
+	}
+	m.lastSync = start
+	m.Unlock()
+	log.Debugf("synced %d issues", len(updated))
+
+	if m.path != "" {
+		return m.save()
+	}
+	return nil
+}
+
+// Periodically sync the mirror until the stop channel is closed.  Errors
+// are logged and retried on the next interval.
+func (m *Mirror) Run(interval time.Duration, stop <-chan struct{}) {
+	log := log.WithField("method", "Run")
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := m.Sync(); err != nil {
+			log.Warn("Unable to sync issues: ", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Add or replace an entry.  Must be called with the lock held.
+func (m *Mirror) add(e *Entry) {
+	m.entries[e.Issue.Number] = e
+	m.idx.Add(makeDoc(e))
+}
+
+// Convert an entry into an indexable document.
+func makeDoc(e *Entry) *index.Doc {
+	iss := e.Issue
+	d := &index.Doc{
+		ID:        iss.Number,
+		State:     iss.State,
+		UpdatedAt: iss.UpdatedAt,
+	}
+	d.Text[index.Title] = iss.Title
+	d.Text[index.Body] = iss.Body
+
+	var comments []string
+	for _, c := range e.Comments {
+		comments = append(comments, c.Body)
+	}
+	d.Text[index.Comments] = strings.Join(comments, "\n")
+
+	for _, l := range iss.Labels {
+		d.Labels = append(d.Labels, l.Name)
+	}
+	d.Text[index.Labels] = strings.Join(d.Labels, " ")
+
+	for _, u := range iss.Assignees {
+		d.Assignees = append(d.Assignees, u.Login)
+	}
+	if len(d.Assignees) == 0 && iss.Assignee != nil {
+		d.Assignees = append(d.Assignees, iss.Assignee.Login)
+	}
+	return d
+}
+
+// Load the mirror from its file.
+func (m *Mirror) load() error {
+	f, err := os.Open(m.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var snap snapshot
+	if err = json.NewDecoder(f).Decode(&snap); err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	for _, e := range snap.Entries {
+		m.add(e)
+	}
+	m.lastSync = snap.LastSync
+	log.Infof("loaded %d issues from %s", len(snap.Entries), m.path)
+	return nil
+}
+
+// Save the mirror to its file.  The file is written to a temporary file
+// first and then renamed so a crash never leaves a partial mirror.
+func (m *Mirror) save() error {
+	m.RLock()
+	snap := snapshot{LastSync: m.lastSync}
+	for _, e := range m.entries {
+		snap.Entries = append(snap.Entries, e)
+	}
+	data, err := json.Marshal(&snap)
+	m.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(m.path), ".mirror")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
@@ -0,0 +1,31 @@
+package mirror
+
+import (
+	"testing"
+
+	"github.com/ctelfer-docker/slkiss/github"
+	"github.com/ctelfer-docker/slkiss/githubtest"
+)
+
+func TestSyncSkipsPullRequests(t *testing.T) {
+	gh := githubtest.NewServer()
+	defer gh.Close()
+	gh.AddIssue("o/r", &github.Issue{Title: "Server crashes on start"})
+	gh.AddIssue("o/r", &github.Issue{Title: "Fix server crash", PullRequest: &github.PullRequestRef{}})
+	gh.AddIssue("o/r", &github.Issue{Title: "Server docs", State: "closed"})
+
+	m, err := New(github.NewRepoAgentURL(gh.URL+"/", "o/r"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, iss := range m.Search("server", 0) {
+		got = append(got, iss.Number)
+	}
+	if len(got) != 2 || got[0] == 2 || got[1] == 2 {
+		t.Errorf("Search(server) after sync = %v, want issues 1 and 3", got)
+	}
+}
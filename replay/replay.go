@@ -0,0 +1,236 @@
+// An http.RoundTripper that records HTTP interactions to a golden file
+// and plays them back later so that code which talks to github or slack
+// can be exercised deterministically and without network access.
+//
+// To record, wrap a real transport and save when done:
+//
+//	rec := replay.NewRecorder(http.DefaultTransport)
+//	github.Client.Transport = rec
+//	... make requests ...
+//	rec.Save("fixtures/search.json")
+//
+// To replay:
+//
+//	rp, err := replay.Load("fixtures/search.json")
+//	github.Client.Transport = rp
+//
+// Secrets in request headers (e.g. Authorization) are redacted before
+// they are recorded.
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// Placeholder for redacted header values
+const Redacted = "REDACTED"
+
+// Headers whose values are never written to a golden file
+var redactHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Slack-Signature"}
+
+// Request is the recorded form of an HTTP request.
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header `json:",omitempty"`
+	Body   string      `json:",omitempty"`
+}
+
+// Response is the recorded form of an HTTP response.
+type Response struct {
+	StatusCode int
+	Status     string
+	Header     http.Header `json:",omitempty"`
+	Body       string      `json:",omitempty"`
+}
+
+// Interaction is one recorded request and its response.
+type Interaction struct {
+	Request  Request
+	Response Response
+}
+
+// Transport records or replays HTTP interactions.
+type Transport struct {
+	mu           sync.Mutex
+	base         http.RoundTripper
+	interactions []*Interaction
+	used         []bool
+}
+
+// Create a transport that passes requests through to base (or
+// http.DefaultTransport if base is nil) and records them.
+func NewRecorder(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{base: base}
+}
+
+// Create a transport that replays the interactions in a golden file.
+func Load(path string) (*Transport, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var interactions []*Interaction
+	if err = json.Unmarshal(data, &interactions); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &Transport{
+		interactions: interactions,
+		used:         make([]bool, len(interactions)),
+	}, nil
+}
+
+// Write the recorded interactions to a golden file.
+func (t *Transport) Save(path string) error {
+	t.mu.Lock()
+	data, err := json.MarshalIndent(t.interactions, "", "  ")
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Return the recorded interactions that have not been replayed.
+func (t *Transport) Unused() []*Interaction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var unused []*Interaction
+	for i, in := range t.interactions {
+		if !t.used[i] {
+			unused = append(unused, in)
+		}
+	}
+	return unused
+}
+
+// Implement http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if t.base == nil {
+		return t.replay(req, body)
+	}
+	return t.record(req, body)
+}
+
+// Pass the request through to the real transport and record it.
+func (t *Transport) record(req *http.Request, body []byte) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	rbody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(rbody))
+
+	in := &Interaction{
+		Request: Request{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: redact(req.Header),
+			Body:   string(body),
+		},
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Header:     redact(resp.Header),
+			Body:       string(rbody),
+		},
+	}
+	t.mu.Lock()
+	t.interactions = append(t.interactions, in)
+	t.mu.Unlock()
+	return resp, nil
+}
+
+// Find the first unused interaction matching the request and return its
+// response.  Requests match if they have the same method, path, query
+// parameters and body.  The scheme and host are ignored so recordings
+// can be replayed against any base URL.
+func (t *Transport) replay(req *http.Request, body []byte) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, in := range t.interactions {
+		if t.used[i] || !matches(in, req, body) {
+			continue
+		}
+		t.used[i] = true
+		return &http.Response{
+			StatusCode:    in.Response.StatusCode,
+			Status:        in.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        cloneHeader(in.Response.Header),
+			Body:          ioutil.NopCloser(bytes.NewReader([]byte(in.Response.Body))),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("replay: no recorded response for %s %s", req.Method, req.URL)
+}
+
+func matches(in *Interaction, req *http.Request, body []byte) bool {
+	if in.Request.Method != req.Method || in.Request.Body != string(body) {
+		return false
+	}
+	u, err := url.Parse(in.Request.URL)
+	if err != nil {
+		return false
+	}
+	// Encode() sorts the parameters so their order doesn't matter
+	return u.Path == req.URL.Path && u.Query().Encode() == req.URL.Query().Encode()
+}
+
+// Read a request body and replace it so it can be read again.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// Return a copy of the headers with secrets replaced.
+func redact(h http.Header) http.Header {
+	c := cloneHeader(h)
+	for _, k := range redactHeaders {
+		if _, ok := c[k]; ok {
+			c.Set(k, Redacted)
+		}
+	}
+	return c
+}
+
+func cloneHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
@@ -0,0 +1,77 @@
+package slack
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ctelfer-docker/slkiss/github"
+)
+
+// Slack response types
+const (
+	inChannel = "in_channel"
+	ephemeral = "ephemeral"
+)
+
+// Commands whose results are announced to the channel unless the config
+// says otherwise
+var announced = map[string]bool{
+	"close":    true,
+	"reopen":   true,
+	"assign":   true,
+	"unassign": true,
+	"label":    true,
+	"new":      true,
+}
+
+// Work out how to reply to a command.  Settings for the channel take
+// precedence over the global settings and settings for the command over
+// the "*" default.
+func (b *IssueBot) responseType(r *http.Request, cmd string) string {
+	b.Lock()
+	defer b.Unlock()
+	var levels []map[string]string
+	for _, ch := range []string{r.PostForm.Get("channel_id"), r.PostForm.Get("channel_name")} {
+		if ch == "" {
+			continue
+		}
+		if cc, ok := b.config.Channels[ch]; ok {
+			levels = append(levels, cc.Responses)
+			break
+		}
+	}
+	levels = append(levels, b.config.Responses)
+	for _, l := range levels {
+		if rt, ok := l[cmd]; ok {
+			return rt
+		}
+		if rt, ok := l["*"]; ok {
+			return rt
+		}
+	}
+	if announced[cmd] {
+		return inChannel
+	}
+	return ephemeral
+}
+
+// Return a mention of the user who sent a request.
+func actor(r *http.Request) string {
+	if id := r.PostForm.Get("user_id"); id != "" {
+		return "<@" + id + ">"
+	}
+	return "@" + r.PostForm.Get("user_name")
+}
+
+// Build the reply to a command that changed an issue, naming who made the
+// change.  For example:  "@alice closed #42 'Crash on start'".  The
+// suffix follows the issue (e.g. " to @bob").
+func (b *IssueBot) announce(r *http.Request, cmd string, a *github.Agent, num int, verb string, suffix string) *response {
+	ref := fmt.Sprintf("#%d", num)
+	if iss, err := a.GetIssue(num); err == nil {
+		ref = fmt.Sprintf("%s '%s'", link(iss.HTMLURL, ref), escape(iss.Title))
+	}
+	resp := newResponse(actor(r) + " " + verb + " " + ref + suffix)
+	resp.ResponseType = b.responseType(r, cmd)
+	return resp
+}
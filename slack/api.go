@@ -0,0 +1,34 @@
+package slack
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ctelfer-docker/slkiss/slackapi"
+)
+
+// HTTP client used for posting to response_urls
+var Client = &http.Client{Timeout: 30 * time.Second}
+
+// Send slack Web API calls to the API rooted at api rather than to slack.
+func (b *IssueBot) SetSlackURL(api string) {
+	b.slackapi = slackapi.NewURL(api, "")
+}
+
+// Return a slack Web API client that calls with a workspace's bot token.
+// Must be called with the lock held.
+func (b *IssueBot) teamAPI(team string) *slackapi.Client {
+	return b.slackapi.WithToken(b.teamToken(team))
+}
+
+// Return a response as a message to post to a channel.  If thread is not
+// empty the message is a reply in that thread.
+func (m *response) message(channel string, thread string) *slackapi.Message {
+	return &slackapi.Message{
+		Channel:     channel,
+		Text:        m.Text,
+		Blocks:      m.Blocks,
+		Attachments: m.Attachments,
+		ThreadTS:    thread,
+	}
+}
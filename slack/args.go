@@ -0,0 +1,597 @@
+package slack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Slash command text is split into words much like a shell would:
+//
+//	/issue new "Crash on start" when offline
+//	/issue bulk label 'help wanted' -label:bug is:open
+//	/issue admin sweep --dry-run
+//
+// Single, double and "smart" quotes group words.  Quotes only start a
+// group at the beginning of a word or after '=' or ':' so apostrophes
+// such as "can't" need no escaping.  A backslash escapes a following
+// quote, backslash, space or ';'.  Slack's escaped entities
+// (<@U123|name>, <#C123|channel>, <https://example.com|text>) are kept
+// whole.  An unquoted ';' separates commands that are run in turn.
+//
+// Each command declares a schema for its arguments.  The words after the
+// command are parsed against it and usage errors are generated from it
+// so that every command reports them the same way.
+
+// A word of slash command text
+type word struct {
+	text    string  // quotes and escapes removed; entities as <@ID>, <#ID>, <!NAME> or the URL
+	display string  // the same with entities shown as people read them
+	ent     *entity // set if the word is a single entity
+	start   int     // offsets of the word in the text
+	end     int
+	quoted  bool
+	sep     bool // a ';' between commands
+}
+
+// A slack entity such as <@U123|name>.  See:
+//   https://api.slack.com/reference/surfaces/formatting#retrieving-messages
+type entity struct {
+	kind  byte   // '@' for users, '#' for channels, '!' for specials, 0 for links
+	id    string // the user or channel ID, special name or URL
+	label string // the name slack shows if it sent one
+}
+
+// Quote characters and the characters that close them
+var quotes = map[rune]rune{
+	'"':      '"',
+	'\'':     '\'',
+	'\u201c': '\u201d', // smart double quotes
+	'\u2018': '\u2019', // smart single quotes
+}
+
+// Characters that slack escapes in slash command text
+var slackEscapes = []string{"&lt;", "&gt;", "&amp;"}
+var slackUnescaper = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+
+// Split slash command text into words.
+func tokenize(s string) ([]*word, error) {
+	var words []*word
+	var w *word
+	var text, display strings.Builder
+	var closer rune // the quote that ends the current group if any
+	ents, plain := 0, false
+	add := func(c string) {
+		text.WriteString(c)
+		display.WriteString(c)
+		plain = true
+	}
+	end := func(i int) {
+		if w == nil {
+			return
+		}
+		w.text = slackUnescaper.Replace(text.String())
+		w.display = slackUnescaper.Replace(display.String())
+		w.end = i
+		if ents != 1 || plain {
+			w.ent = nil
+		}
+		words = append(words, w)
+		w, ents, plain = nil, 0, false
+		text.Reset()
+		display.Reset()
+	}
+
+	for i := 0; i < len(s); {
+		c, n := utf8.DecodeRuneInString(s[i:])
+		if w == nil && !unicode.IsSpace(c) {
+			w = &word{start: i}
+		}
+		switch {
+		case c == '<':
+			// slack escapes '<' in what users type so this is an entity
+			j := strings.IndexByte(s[i:], '>')
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated %q", "<")
+			}
+			w.ent = parseEntity(s[i+1 : i+j])
+			text.WriteString(w.ent.canonical())
+			display.WriteString(w.ent.String())
+			ents++
+			n = j + 1
+		case c == '\\' && i+n < len(s) && strings.IndexByte("\\\"' ;", s[i+n]) >= 0:
+			add(s[i+n : i+n+1])
+			n++
+		case c == '&' && slackEscape(s[i:]) != "":
+			// kept whole so that its ';' doesn't separate commands
+			add(slackEscape(s[i:]))
+			n = len(slackEscape(s[i:]))
+		case closer != 0:
+			if c == closer {
+				closer = 0
+			} else {
+				add(string(c))
+			}
+		case unicode.IsSpace(c):
+			end(i)
+		case c == ';':
+			if w.start == i {
+				w = nil // the ';' started no word
+			}
+			end(i)
+			words = append(words, &word{text: ";", display: ";", start: i, end: i + 1, sep: true})
+		default:
+			t := text.String()
+			if q, ok := quotes[c]; ok && (t == "" || strings.HasSuffix(t, "=") || strings.HasSuffix(t, ":")) {
+				closer = q
+				w.quoted = true
+			} else {
+				add(string(c))
+			}
+		}
+		i += n
+	}
+	if closer != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	end(len(s))
+	return words, nil
+}
+
+// Return the slack escape sequence that s starts with if any.
+func slackEscape(s string) string {
+	for _, e := range slackEscapes {
+		if strings.HasPrefix(s, e) {
+			return e
+		}
+	}
+	return ""
+}
+
+// Split words at ';' separators into the words of each command.  Empty
+// commands are dropped.
+func splitCommands(words []*word) [][]*word {
+	var cmds [][]*word
+	start := 0
+	for i := 0; i <= len(words); i++ {
+		if i < len(words) && !words[i].sep {
+			continue
+		}
+		if i > start {
+			cmds = append(cmds, words[start:i])
+		}
+		start = i + 1
+	}
+	return cmds
+}
+
+// Parse the inside of <...> in slash command text.
+func parseEntity(s string) *entity {
+	e := &entity{}
+	if i := strings.IndexByte(s, '|'); i >= 0 {
+		s, e.label = s[:i], s[i+1:]
+	}
+	if s != "" && strings.IndexByte("@#!", s[0]) >= 0 {
+		e.kind, e.id = s[0], s[1:]
+	} else {
+		e.id = s
+	}
+	return e
+}
+
+// Return an entity in the form that identifies it.
+func (e *entity) canonical() string {
+	if e.kind == 0 {
+		return e.id
+	}
+	return "<" + string(e.kind) + e.id + ">"
+}
+
+// Return an entity as people read it.
+func (e *entity) String() string {
+	switch {
+	case e.kind == 0:
+		return e.id
+	case e.label != "":
+		return string(e.kind) + strings.TrimPrefix(e.label, string(e.kind))
+	case e.kind == '!':
+		return "@" + e.id
+	}
+	return e.canonical()
+}
+
+// Kinds of command arguments
+type argKind int
+
+const (
+	argWord  argKind = iota // any word; entities are kept as <@ID> or <#ID>
+	argText                 // free text; entities are shown as people read them
+	argIssue                // an issue number:  NUM or #NUM
+	argUser                 // <@USERID>, @SLACKNAME, @me or GITHUBNAME
+	argQuery                // search terms passed on as typed
+)
+
+// An argument of a command
+type argSpec struct {
+	name     string // e.g. "NUM" as shown in usage
+	kind     argKind
+	optional bool
+	rest     bool // takes every remaining word
+}
+
+// A --flag of a command
+type flagSpec struct {
+	name  string // long name without "--"
+	short string // one letter name without "-" if any
+	value string // name of the flag's value as shown in usage or "" for switches
+}
+
+// The arguments of a command or subcommand.  A schema either has
+// subcommands or arguments of its own.
+type schema struct {
+	name  string // the subcommand's name
+	args  []*argSpec
+	flags []*flagSpec
+	subs  []*schema
+	note  string // explains the arguments after the usage
+}
+
+// Schema of commands without arguments
+var noArgs = &schema{}
+
+// Schema of commands that take one issue number
+var issueArgs = &schema{args: []*argSpec{{name: "NUM", kind: argIssue}}}
+
+// Args holds the parsed arguments of a command.
+type Args struct {
+	cmd   string // the command and subcommand run, e.g. "bulk label"
+	sub   string // the subcommand run if any
+	vals  map[string][]string
+	raw   map[string]string
+	flags map[string]string
+}
+
+// A problem with a command's arguments along with the command's usage
+type usageError struct {
+	problem string
+	usage   string
+}
+
+func (e *usageError) Error() string {
+	switch {
+	case e.problem == "":
+		return e.usage
+	case e.usage == "":
+		return e.problem
+	}
+	return e.problem + "\n" + e.usage
+}
+
+// Return the usage message for a schema.  path is the command and any
+// subcommands leading to it.
+func (s *schema) usage(path string) string {
+	var lines, notes []string
+	s.usageLines(path, &lines, &notes)
+	msg := "usage: " + lines[0]
+	if len(lines) > 1 {
+		msg = "usage:\n\t" + strings.Join(lines, "\n\t")
+	}
+	for _, n := range notes {
+		msg += "\n" + n
+	}
+	return msg
+}
+
+func (s *schema) usageLines(path string, lines *[]string, notes *[]string) {
+	if s.note != "" {
+		*notes = append(*notes, s.note)
+	}
+	if len(s.subs) > 0 {
+		for _, sub := range s.subs {
+			sub.usageLines(path+" "+sub.name, lines, notes)
+		}
+		return
+	}
+	*lines = append(*lines, s.synopsis(path))
+}
+
+// Return a one line summary of a schema's usage, e.g.
+// "/issue assign NUM USER" or "/issue admin sweep|audit ...".
+func (s *schema) synopsis(path string) string {
+	line := "/issue " + path
+	if len(s.subs) > 0 {
+		var names []string
+		for _, sub := range s.subs {
+			names = append(names, sub.name)
+		}
+		return line + " " + strings.Join(names, "|") + " ..."
+	}
+	for _, f := range s.flags {
+		opt := "--" + f.name
+		if f.short != "" {
+			opt = "-" + f.short + "|" + opt
+		}
+		if f.value != "" {
+			opt += "=" + f.value
+		}
+		line += " [" + opt + "]"
+	}
+	for _, a := range s.args {
+		name := a.name
+		if a.rest {
+			name += "..."
+		}
+		if a.optional {
+			name = "[" + name + "]"
+		}
+		line += " " + name
+	}
+	return line
+}
+
+// Return the subcommand with a name or nil.
+func (s *schema) sub(name string) *schema {
+	for _, sub := range s.subs {
+		if sub.name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// Return the error for an unknown subcommand.  The usage is only given
+// when there is no likely subcommand to suggest.
+func (s *schema) unknownSub(name string, path string) error {
+	problem := fmt.Sprintf("unknown subcommand %q", name)
+	var names []string
+	for _, sub := range s.subs {
+		names = append(names, sub.name)
+	}
+	if sugg := suggest(name, names); len(sugg) > 0 {
+		return &usageError{problem: problem + ", " + didYouMean(sugg)}
+	}
+	return &usageError{problem, s.usage(path)}
+}
+
+// Parse the usage of a command added with RegisterCommand() into a
+// schema.  See CommandSpec for the format.
+func parseUsage(usage string) (*schema, error) {
+	s := &schema{}
+	for _, tok := range strings.Fields(usage) {
+		optional := strings.HasPrefix(tok, "[") && strings.HasSuffix(tok, "]")
+		name := strings.TrimSuffix(strings.TrimPrefix(tok, "["), "]")
+		if strings.HasPrefix(name, "-") {
+			if !optional {
+				return nil, fmt.Errorf("option %s must be in [...]", tok)
+			}
+			f, err := parseFlagUsage(name)
+			if err != nil {
+				return nil, err
+			}
+			s.flags = append(s.flags, f)
+			continue
+		}
+
+		spec := &argSpec{name: strings.TrimSuffix(name, "..."), optional: optional}
+		spec.rest = spec.name != name
+		if spec.name == "" || strings.ContainsAny(spec.name, "[]|=.") {
+			return nil, fmt.Errorf("invalid argument %s", tok)
+		}
+		switch {
+		case spec.rest:
+			spec.kind = argText
+		case spec.name == "NUM" || spec.name == "ISSUE":
+			spec.kind = argIssue
+		case spec.name == "USER":
+			spec.kind = argUser
+		}
+		if n := len(s.args); n > 0 {
+			prev := s.args[n-1]
+			if prev.rest {
+				return nil, fmt.Errorf("%s... must be the last argument", prev.name)
+			}
+			if prev.optional && !spec.optional {
+				return nil, fmt.Errorf("%s can't follow the optional %s", spec.name, prev.name)
+			}
+		}
+		s.args = append(s.args, spec)
+	}
+	return s, nil
+}
+
+// Parse an option from a usage such as "--name", "--name=VALUE" or
+// "-n|--name".
+func parseFlagUsage(opt string) (*flagSpec, error) {
+	f := &flagSpec{}
+	for _, n := range strings.Split(opt, "|") {
+		if i := strings.IndexByte(n, '='); i >= 0 {
+			n, f.value = n[:i], n[i+1:]
+		}
+		switch {
+		case len(n) > 3 && strings.HasPrefix(n, "--"):
+			f.name = n[2:]
+		case len(n) == 2 && n[0] == '-' && n[1] != '-':
+			f.short = n[1:]
+		default:
+			return nil, fmt.Errorf("invalid option %s", opt)
+		}
+	}
+	if f.name == "" {
+		return nil, fmt.Errorf("option %s needs a --name", opt)
+	}
+	return f, nil
+}
+
+// Parse the words that follow the command name.  text is the command text
+// the words came from.
+func (s *schema) parse(name string, text string, words []*word) (*Args, error) {
+	a := &Args{
+		vals:  make(map[string][]string),
+		raw:   make(map[string]string),
+		flags: make(map[string]string),
+	}
+	path := name
+	for len(s.subs) > 0 {
+		if len(words) == 0 {
+			return nil, &usageError{usage: s.usage(path)}
+		}
+		sub := s.sub(words[0].text)
+		if sub == nil {
+			return nil, s.unknownSub(words[0].text, path)
+		}
+		s, words = sub, words[1:]
+		path += " " + s.name
+		a.sub = s.name
+	}
+	a.cmd = path
+	fail := func(format string, v ...interface{}) error {
+		return &usageError{fmt.Sprintf(format, v...), s.usage(path)}
+	}
+
+	next := 0    // the next argument to fill
+	opts := true // words starting with '-' are options if the command has any
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if opts && !w.quoted && w.text == "--" {
+			opts = false
+			continue
+		}
+		if opts && len(s.flags) > 0 && isOption(w) {
+			f, val, err := s.flag(w.text)
+			if err != nil {
+				return nil, fail("%s", err)
+			}
+			if f.value != "" && val == "" {
+				if i+1 == len(words) {
+					return nil, fail("--%s needs a %s", f.name, f.value)
+				}
+				i++
+				val = words[i].text
+			}
+			if f.value == "" {
+				val = "true"
+			}
+			a.flags[f.name] = val
+			continue
+		}
+		if next < len(s.args) && s.args[next].rest {
+			// the remaining words may be search terms such as
+			// "-label:bug" so options must come before them
+			spec := s.args[next]
+			for _, rw := range words[i:] {
+				v, err := spec.value(rw)
+				if err != nil {
+					return nil, fail("%s", err)
+				}
+				a.vals[spec.name] = append(a.vals[spec.name], v)
+			}
+			a.raw[spec.name] = slackUnescaper.Replace(text[w.start:words[len(words)-1].end])
+			next++
+			break
+		}
+		if next == len(s.args) {
+			return nil, fail("unexpected argument %q", w.text)
+		}
+		spec := s.args[next]
+		v, err := spec.value(w)
+		if err != nil {
+			return nil, fail("%s", err)
+		}
+		a.vals[spec.name] = []string{v}
+		a.raw[spec.name] = slackUnescaper.Replace(text[w.start:w.end])
+		next++
+	}
+	for _, spec := range s.args[next:] {
+		if !spec.optional {
+			return nil, fail("missing %s", spec.name)
+		}
+	}
+	return a, nil
+}
+
+// Find the flag named by a word such as "--name=value" or "-n".  Returns
+// the flag and the value given with '=' if any.
+func (s *schema) flag(w string) (*flagSpec, string, error) {
+	name, val := strings.TrimLeft(w, "-"), ""
+	if i := strings.IndexByte(name, '='); i >= 0 {
+		name, val = name[:i], name[i+1:]
+	}
+	long := strings.HasPrefix(w, "--")
+	for _, f := range s.flags {
+		if (long && f.name == name) || (!long && f.short != "" && f.short == name) {
+			if f.value == "" && val != "" {
+				return nil, "", fmt.Errorf("--%s doesn't take a value", f.name)
+			}
+			return f, val, nil
+		}
+	}
+	return nil, "", fmt.Errorf("unknown option %s", strings.SplitN(w, "=", 2)[0])
+}
+
+// Returns true if a word is an option such as "--name" or "-n" rather
+// than an argument such as "-" or "-12".
+func isOption(w *word) bool {
+	if w.quoted || len(w.text) < 2 || w.text[0] != '-' {
+		return false
+	}
+	_, err := strconv.Atoi(w.text)
+	return err != nil
+}
+
+// Check a word against an argument's kind and return its value.
+func (spec *argSpec) value(w *word) (string, error) {
+	switch spec.kind {
+	case argText, argQuery:
+		return w.display, nil
+	case argIssue:
+		n, err := strconv.Atoi(strings.TrimPrefix(w.text, "#"))
+		if err != nil || n <= 0 {
+			return "", fmt.Errorf("%s must be an issue number, not %q", spec.name, w.display)
+		}
+		return strconv.Itoa(n), nil
+	case argUser:
+		if w.ent != nil && w.ent.kind != '@' {
+			return "", fmt.Errorf("%s must be a user, not %q", spec.name, w.display)
+		}
+	}
+	return w.text, nil
+}
+
+// Returns true if an argument or flag was given.
+func (a *Args) Has(name string) bool {
+	_, ok := a.vals[name]
+	if !ok {
+		_, ok = a.flags[name]
+	}
+	return ok
+}
+
+// Return the value of an argument.  The words of an argument that takes
+// the remaining words are joined with spaces.
+func (a *Args) Get(name string) string {
+	return strings.Join(a.vals[name], " ")
+}
+
+// Return the words of an argument that takes the remaining words.
+func (a *Args) List(name string) []string {
+	return a.vals[name]
+}
+
+// Return an argument as it was typed, with quotes and escapes intact.
+func (a *Args) Text(name string) string {
+	return a.raw[name]
+}
+
+// Return the value of an issue number argument or 0 if it wasn't given.
+func (a *Args) Num(name string) int {
+	n, _ := strconv.Atoi(a.Get(name))
+	return n
+}
+
+// Return the value of a flag or "" if it wasn't given.  Switches have the
+// value "true".
+func (a *Args) Flag(name string) string {
+	return a.flags[name]
+}
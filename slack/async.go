@@ -0,0 +1,122 @@
+package slack
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits on background commands
+const (
+	maxJobs      = 16                     // most commands running at once
+	postAttempts = 4                      // tries to deliver each result
+	postBackoff  = 500 * time.Millisecond // delay before the first retry
+	ackMessage   = "working on it…"
+	busyMessage  = "issuebot is busy:  please try again shortly"
+)
+
+// Collects a handler's response so that it can be sent to slack later
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedWriter() *bufferedWriter {
+	return &bufferedWriter{header: make(http.Header), status: http.StatusOK}
+}
+
+// Implement http.ResponseWriter.
+func (bw *bufferedWriter) Header() http.Header {
+	return bw.header
+}
+
+// Implement http.ResponseWriter.
+func (bw *bufferedWriter) Write(p []byte) (int, error) {
+	return bw.body.Write(p)
+}
+
+// Implement http.ResponseWriter.
+func (bw *bufferedWriter) WriteHeader(status int) {
+	bw.status = status
+}
+
+// Return the response as a message for a response_url.  Plain text
+// responses become ephemeral messages.
+func (bw *bufferedWriter) message() []byte {
+	if strings.HasPrefix(bw.header.Get("Content-Type"), "application/json") {
+		return bw.body.Bytes()
+	}
+	return ephemeralMessage(bw.body.String())
+}
+
+// Acknowledge a command and run its handler in the background, sending
+// the result to the request's response_url.  If too many commands are
+// already running the user is asked to try again.
+func (b *IssueBot) runAsync(w http.ResponseWriter, r *http.Request, h CommandFunc, a *Args) {
+	select {
+	case b.jobs <- struct{}{}:
+	default:
+		w.Write([]byte(busyMessage))
+		return
+	}
+	w.Write([]byte(ackMessage))
+
+	go func() {
+		log := log.WithField("method", "runAsync")
+		defer func() { <-b.jobs }()
+		bw := newBufferedWriter()
+		h(b, bw, r, a)
+		if err := postResponse(r.PostForm.Get("response_url"), bw.message()); err != nil {
+			log.Warn("Unable to send command result: ", err)
+		}
+	}()
+}
+
+// Post a message to a response_url retrying with exponential backoff on
+// network errors, server errors and rate limiting.
+func postResponse(url string, msg []byte) error {
+	var err error
+	delay := postBackoff
+	for i := 0; i < postAttempts; i++ {
+		if i > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		var retryAfter time.Duration
+		retryAfter, err = postOnce(url, msg)
+		if err == nil {
+			return nil
+		}
+		if retryAfter < 0 {
+			return err
+		}
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+	}
+	return err
+}
+
+// Make one attempt to post a message.  On failure returns how long slack
+// asked us to wait before retrying or -1 if retrying won't help.
+func postOnce(url string, msg []byte) (time.Duration, error) {
+	resp, err := Client.Post(url, "application/json", bytes.NewReader(msg))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		secs, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return time.Duration(secs) * time.Second, fmt.Errorf("response_url: %s", resp.Status)
+	case resp.StatusCode >= 500:
+		return 0, fmt.Errorf("response_url: %s", resp.Status)
+	}
+	return -1, fmt.Errorf("response_url: %s", resp.Status)
+}
@@ -0,0 +1,296 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctelfer-docker/slkiss/audit"
+	"github.com/ctelfer-docker/slkiss/github"
+)
+
+// Limits on what '/issue admin audit' shows
+const (
+	auditListed    = 20  // most entries listed
+	auditResultLen = 500 // longest reply kept in an entry
+)
+
+// Where a request's audit record is kept in its context
+type auditKey struct{}
+
+// An audit entry that is being filled in.  Calls may be added from
+// several goroutines (e.g. by bulk operations).
+type auditRecord struct {
+	sync.Mutex
+	entry *audit.Entry
+	start time.Time
+}
+
+// Record commands and the changes they make to github in l.  This must be
+// called before Run().
+func (b *IssueBot) SetAuditLog(l *audit.Log) {
+	b.Lock()
+	defer b.Unlock()
+	b.audit = l
+}
+
+// Allow the audit log to be downloaded from /audit by requests that carry
+// "Authorization: Bearer TOKEN".  An empty token disables downloads.
+func (b *IssueBot) SetAuditToken(token string) {
+	b.Lock()
+	defer b.Unlock()
+	b.auditTok = token
+}
+
+// Start an audit record for something a slack user did.  r may be nil
+// for things the bot does on its own, such as scheduled sweeps.
+func (b *IssueBot) startAudit(r *http.Request, source string, text string) *auditRecord {
+	e := &audit.Entry{Time: time.Now().UTC(), Source: source, Text: text}
+	if r != nil {
+		b.Lock()
+		e.Repo = b.teamRepo(r)
+		b.Unlock()
+		e.Team = r.PostForm.Get("team_id")
+		e.User = r.PostForm.Get("user_id")
+		e.UserName = r.PostForm.Get("user_name")
+		e.Channel = r.PostForm.Get("channel_id")
+	}
+	return &auditRecord{entry: e, start: time.Now()}
+}
+
+// Add a github change to the record.  (Passed to Agent.WithObserver().)
+func (rec *auditRecord) observe(c *github.Call) {
+	ac := &audit.Call{Method: c.Method, Path: c.Path, Issue: c.Issue}
+	if c.Body != nil {
+		ac.Body, _ = json.Marshal(c.Body)
+	}
+	if c.Err != nil {
+		ac.Error = c.Err.Error()
+	}
+	rec.Lock()
+	rec.entry.Calls = append(rec.entry.Calls, ac)
+	rec.Unlock()
+}
+
+// Complete a record with the reply the user got and add it to the log.
+func (b *IssueBot) finishAudit(rec *auditRecord, result string) {
+	if r := []rune(result); len(r) > auditResultLen {
+		result = string(r[:auditResultLen]) + "…"
+	}
+	rec.Lock()
+	e := rec.entry
+	e.Result = result
+	e.LatencyMS = int64(time.Since(rec.start) / time.Millisecond)
+	rec.Unlock()
+
+	b.Lock()
+	l := b.audit
+	b.Unlock()
+	if err := l.Append(e); err != nil {
+		log.WithField("method", "finishAudit").Error("Unable to write audit entry: ", err)
+	}
+}
+
+// Return the audit record of a request or nil.
+func auditOf(r *http.Request) *auditRecord {
+	rec, _ := r.Context().Value(auditKey{}).(*auditRecord)
+	return rec
+}
+
+// Return a request that carries an audit record.
+func withAudit(r *http.Request, rec *auditRecord) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), auditKey{}, rec))
+}
+
+// Return an agent that adds the changes it makes to the audit record of a
+// request if it has one.
+func auditAgent(r *http.Request, a *github.Agent) *github.Agent {
+	if rec := auditOf(r); rec != nil {
+		return a.WithObserver(rec.observe)
+	}
+	return a
+}
+
+// Collects a handler's reply for the audit log while passing it on
+type auditWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+// Implement http.ResponseWriter.
+func (aw *auditWriter) Write(p []byte) (int, error) {
+	aw.body.Write(p)
+	return aw.ResponseWriter.Write(p)
+}
+
+// Return a handler that completes rec with the reply of the command it
+// runs and adds it to the audit log.
+func audited(rec *auditRecord, h CommandFunc) CommandFunc {
+	return func(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
+		aw := &auditWriter{ResponseWriter: w}
+		h(b, aw, withAudit(r, rec), a)
+		b.finishAudit(rec, replyText(aw.body.Bytes()))
+	}
+}
+
+// Return the text of a reply that may be a JSON message.
+func replyText(body []byte) string {
+	var m struct {
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(body, &m) == nil && m.Text != "" {
+		return m.Text
+	}
+	return string(body)
+}
+
+// Parse '/issue admin audit' arguments.  Each argument is an issue (#NUM
+// or NUM), a time (a duration such as "2h" or "3d" meaning that long ago,
+// or a date such as 2019-06-01) or a slack user (<@USERID>, @NAME, a user
+// ID or a name).
+func parseAuditQuery(args []string, now time.Time) (*audit.Query, error) {
+	q := &audit.Query{}
+	for _, arg := range args {
+		// numbers are always issues so that "0" is an error and not a user
+		if _, err := strconv.Atoi(strings.TrimPrefix(arg, "#")); err == nil {
+			n, err := parseAuditIssue(arg)
+			if err != nil {
+				return nil, err
+			}
+			q.Issue = n
+			continue
+		}
+		if t, err := parseAuditSince(arg, now); err == nil {
+			q.Since = t
+			continue
+		}
+		q.User = parseAuditUser(arg)
+	}
+	return q, nil
+}
+
+// Parse an issue number for an audit query, e.g. "#123" or "123".
+func parseAuditIssue(arg string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid issue %q", arg)
+	}
+	return n, nil
+}
+
+// Parse the start of an audit query:  a duration before now such as "2h"
+// or "3d", a date or an RFC 3339 time.
+func parseAuditSince(arg string, now time.Time) (time.Time, error) {
+	if d, err := parseDuration(arg); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse("2006-01-02", arg); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, arg); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", arg)
+}
+
+// Parse a user for an audit query:  a mention, "@NAME" or an ID.
+func parseAuditUser(arg string) string {
+	if m := mentionRe.FindStringSubmatch(arg); m != nil {
+		return m[1]
+	}
+	return strings.TrimPrefix(arg, "@")
+}
+
+// Describe an audit entry in one line for slack.
+func describeEntry(e *audit.Entry) string {
+	who := "the bot"
+	if e.User != "" {
+		who = "<@" + e.User + ">"
+	}
+	where := ""
+	if e.Channel != "" {
+		where = " in <#" + e.Channel + ">"
+	}
+	s := fmt.Sprintf("%s %s%s (%s): `%s`", e.Time.Format("2006-01-02 15:04:05"), who, where, e.Source, e.Text)
+	for _, c := range e.Calls {
+		s += fmt.Sprintf("\n\t\t%s %s", c.Method, c.Path)
+		if c.Error != "" {
+			s += " failed: " + c.Error
+		}
+	}
+	return s + fmt.Sprintf("  (%dms)", e.LatencyMS)
+}
+
+// Arguments of '/issue admin audit'
+var auditArgs = &schema{
+	name: "audit",
+	args: []*argSpec{{name: "FILTER", optional: true, rest: true}},
+	note: "FILTER is any of @USER, #ISSUE and SINCE (e.g. 2h, 3d or 2019-06-01)",
+}
+
+// /issue admin audit [FILTER...]
+func adminAudit(b *IssueBot, r *http.Request, a *Args) string {
+	q, err := parseAuditQuery(a.List("FILTER"), time.Now())
+	if err != nil {
+		return (&usageError{err.Error(), auditArgs.usage("admin audit")}).Error()
+	}
+	q.Limit = auditListed
+	b.Lock()
+	l := b.audit
+	b.Unlock()
+	entries := l.Query(q)
+	if len(entries) == 0 {
+		return "No matching audit entries"
+	}
+	msg := fmt.Sprintf("The last %d matching audit entries:", len(entries))
+	for _, e := range entries {
+		msg += "\n\t" + describeEntry(e)
+	}
+	return msg
+}
+
+// Handle requests to /audit.  Matching entries are sent as JSON lines.
+// The query parameters "user", "issue" and "since" take the same values
+// as the arguments of '/issue admin audit'.
+func (b *IssueBot) serveAudit(w http.ResponseWriter, r *http.Request) {
+	b.Lock()
+	tok := b.auditTok
+	l := b.audit
+	b.Unlock()
+	auth := []byte(r.Header.Get("Authorization"))
+	if tok == "" || subtle.ConstantTimeCompare(auth, []byte("Bearer "+tok)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// unlike the slash command each filter is named so none are guessed
+	q := &audit.Query{}
+	params := r.URL.Query()
+	if v := params.Get("user"); v != "" {
+		q.User = parseAuditUser(v)
+	}
+	var err error
+	if v := params.Get("issue"); v != "" {
+		if q.Issue, err = parseAuditIssue(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("since"); v != "" {
+		if q.Since, err = parseAuditSince(v, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if err = l.Export(w, q); err != nil {
+		log.WithField("method", "serveAudit").Warn("Unable to export audit log: ", err)
+	}
+}
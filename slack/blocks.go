@@ -0,0 +1,304 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ctelfer-docker/slkiss/github"
+)
+
+// A slack message built from Block Kit blocks.  See:
+//
+//	https://api.slack.com/block-kit
+//
+// Text is the plain text fallback shown in notifications and by clients
+// that can't display blocks.
+type response struct {
+	ResponseType string        `json:"response_type,omitempty"`
+	Text         string        `json:"text"`
+	Blocks       []*block      `json:"blocks,omitempty"`
+	Attachments  []*attachment `json:"attachments,omitempty"`
+}
+
+// One Block Kit layout block
+type block struct {
+	Type     string        `json:"type"`
+	BlockID  string        `json:"block_id,omitempty"`
+	Text     *textObject   `json:"text,omitempty"`
+	Fields   []*textObject `json:"fields,omitempty"`
+	Elements []interface{} `json:"elements,omitempty"`
+
+	// input blocks in modals
+	Label          *textObject `json:"label,omitempty"`
+	Element        *element    `json:"element,omitempty"`
+	Optional       bool        `json:"optional,omitempty"`
+	DispatchAction bool        `json:"dispatch_action,omitempty"`
+}
+
+// A Block Kit text object
+type textObject struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// An interactive element such as a button or menu
+type element struct {
+	Type        string      `json:"type"`
+	ActionID    string      `json:"action_id"`
+	Text        *textObject `json:"text,omitempty"`
+	Value       string      `json:"value,omitempty"`
+	Style       string      `json:"style,omitempty"`
+	Placeholder *textObject `json:"placeholder,omitempty"`
+	Options     []*option   `json:"options,omitempty"`
+
+	// initial values and settings for modal inputs
+	InitialOption *option `json:"initial_option,omitempty"`
+	InitialValue  string  `json:"initial_value,omitempty"`
+	Multiline     bool    `json:"multiline,omitempty"`
+	MaxLength     int     `json:"max_length,omitempty"`
+}
+
+// One choice in a menu
+type option struct {
+	Text  *textObject `json:"text"`
+	Value string      `json:"value"`
+}
+
+// Attachments are only used to draw a colored bar beside their blocks
+type attachment struct {
+	Color  string   `json:"color,omitempty"`
+	Blocks []*block `json:"blocks"`
+}
+
+// Slack limits on block contents
+const (
+	maxHeaderLen  = 150
+	maxSectionLen = 3000
+	maxOptionLen  = 75
+	maxOptions    = 100
+)
+
+// Colors and badges for issue states
+var stateColors = map[string]string{
+	"open":   "#2cbe4e",
+	"closed": "#6f42c1",
+}
+var stateBadges = map[string]string{
+	"open":   ":large_green_circle: Open",
+	"closed": ":large_purple_circle: Closed",
+}
+
+// Start a new message with a plain text fallback.
+func newResponse(text string) *response {
+	return &response{Text: text}
+}
+
+func plainText(s string) *textObject {
+	return &textObject{Type: "plain_text", Text: truncate(s, maxHeaderLen)}
+}
+
+func mrkdwn(s string) *textObject {
+	return &textObject{Type: "mrkdwn", Text: truncate(s, maxSectionLen)}
+}
+
+func headerBlock(s string) *block {
+	return &block{Type: "header", Text: plainText(s)}
+}
+
+func sectionBlock(s string) *block {
+	return &block{Type: "section", Text: mrkdwn(s)}
+}
+
+// A section of short "*Name:* value" fields laid out in two columns
+func fieldsBlock(kv ...string) *block {
+	b := &block{Type: "section"}
+	for i := 0; i+1 < len(kv); i += 2 {
+		b.Fields = append(b.Fields, mrkdwn("*"+kv[i]+":*\n"+kv[i+1]))
+	}
+	return b
+}
+
+func actionsBlock(elements ...*element) *block {
+	b := &block{Type: "actions"}
+	for _, e := range elements {
+		b.Elements = append(b.Elements, e)
+	}
+	return b
+}
+
+func button(actionID string, text string, value string, style string) *element {
+	return &element{Type: "button", ActionID: actionID, Text: plainText(text), Value: value, Style: style}
+}
+
+// A menu with one option for each choice.  The choices are used as both
+// the option text and value.
+func staticSelect(actionID string, placeholder string, choices []string) *element {
+	e := &element{Type: "static_select", ActionID: actionID, Placeholder: plainText(placeholder)}
+	for i, c := range choices {
+		if i == maxOptions {
+			break
+		}
+		e.Options = append(e.Options, newOption(c, c))
+	}
+	return e
+}
+
+func newOption(text string, value string) *option {
+	return &option{Text: &textObject{Type: "plain_text", Text: truncate(text, maxOptionLen)}, Value: value}
+}
+
+// An input block for a modal.
+func inputBlock(blockID string, label string, e *element, optional bool) *block {
+	return &block{Type: "input", BlockID: blockID, Label: plainText(label), Element: e, Optional: optional}
+}
+
+func contextBlock(s ...string) *block {
+	b := &block{Type: "context"}
+	for _, e := range s {
+		b.Elements = append(b.Elements, mrkdwn(e))
+	}
+	return b
+}
+
+// Add blocks to the message.
+func (m *response) add(blocks ...*block) *response {
+	m.Blocks = append(m.Blocks, blocks...)
+	return m
+}
+
+// Add blocks to the message with a colored bar beside them.
+func (m *response) addColored(color string, blocks ...*block) *response {
+	m.Attachments = append(m.Attachments, &attachment{Color: color, Blocks: blocks})
+	return m
+}
+
+// Write the message as the response to a slash command.
+func (m *response) write(w http.ResponseWriter) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.WithField("method", "response.write").Error("Unable to encode response: ", err)
+		w.Write([]byte(m.Text))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(data)
+}
+
+// Write a rich response if there is one and plain text otherwise.  This
+// lets handlers keep using the deferred write of a usage message.
+func writeResponse(w http.ResponseWriter, resp *response, msg string) {
+	if resp == nil {
+		w.Write([]byte(msg))
+		return
+	}
+	resp.write(w)
+}
+
+// Escape the characters that have special meaning in slack mrkdwn.
+func escape(s string) string {
+	s = strings.Replace(s, "&", "&amp;", -1)
+	s = strings.Replace(s, "<", "&lt;", -1)
+	return strings.Replace(s, ">", "&gt;", -1)
+}
+
+// Format a mrkdwn link.
+func link(url string, text string) string {
+	return "<" + url + "|" + escape(text) + ">"
+}
+
+func stateBadge(state string) string {
+	if b, ok := stateBadges[state]; ok {
+		return b
+	}
+	return escape(state)
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-1]) + "…"
+}
+
+// Blocks that describe an issue have IDs of the form "issue:NUM:PART" so
+// that they can be found and replaced when the issue changes.
+func issueBlockID(num int, part string) string {
+	return fmt.Sprintf("issue:%d:%s", num, part)
+}
+
+// Return the issue number from a block ID made by issueBlockID().
+func blockIssue(id string) (int, bool) {
+	f := strings.Split(id, ":")
+	if len(f) != 3 || f[0] != "issue" {
+		return 0, false
+	}
+	num, err := strconv.Atoi(f[1])
+	return num, err == nil
+}
+
+// Build the blocks describing one issue.  Assignees are given as display
+// names.  labels are the repository's labels to offer in the "Add label"
+// menu.
+func issueBlocks(repo string, iss *github.Issue, assignees []string, labels []string) []*block {
+	who := "_Unassigned_"
+	if len(assignees) > 0 {
+		who = escape(strings.Join(assignees, ", "))
+	}
+	var tags []string
+	for _, l := range iss.Labels {
+		tags = append(tags, "`"+escape(l.Name)+"`")
+	}
+	lstr := "_None_"
+	if len(tags) > 0 {
+		lstr = strings.Join(tags, " ")
+	}
+	fields := []string{"State", stateBadge(iss.State), "Assignees", who, "Labels", lstr}
+	if iss.Milestone != nil {
+		fields = append(fields, "Milestone", escape(iss.Milestone.Title))
+	}
+	blocks := []*block{
+		headerBlock(fmt.Sprintf("#%d %s", iss.Number, iss.Title)),
+		fieldsBlock(fields...),
+		contextBlock(link(iss.HTMLURL, fmt.Sprintf("%s#%d", repo, iss.Number))),
+		issueActions(iss, labels),
+	}
+	for i, part := range []string{"header", "fields", "context"} {
+		blocks[i].BlockID = issueBlockID(iss.Number, part)
+	}
+	return blocks
+}
+
+// Build the blocks for one issue in a list of issues.
+func issueListBlocks(iss *github.Issue, labels []string) []*block {
+	summary := sectionBlock(fmt.Sprintf("%s *%s*\n%s",
+		link(iss.HTMLURL, fmt.Sprintf("#%d", iss.Number)), escape(iss.Title), stateBadge(iss.State)))
+	summary.BlockID = issueBlockID(iss.Number, "summary")
+	return []*block{summary, issueActions(iss, labels)}
+}
+
+// Build the triage buttons for an issue.
+func issueActions(iss *github.Issue, labels []string) *block {
+	num := strconv.Itoa(iss.Number)
+	state := button(actionClose, "Close", num, "danger")
+	if iss.State == "closed" {
+		state = button(actionReopen, "Reopen", num, "primary")
+	}
+	elements := []*element{state, button(actionAssignMe, "Assign to me", num, "")}
+
+	var choices []string
+	for _, l := range labels {
+		if !hasAnyLabel(iss, []string{l}) {
+			choices = append(choices, l)
+		}
+	}
+	if len(choices) > 0 {
+		elements = append(elements, staticSelect(actionLabel, "Add label", choices))
+	}
+	b := actionsBlock(elements...)
+	b.BlockID = issueBlockID(iss.Number, "actions")
+	return b
+}
@@ -0,0 +1,429 @@
+package slack
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctelfer-docker/slkiss/github"
+)
+
+// Limits on bulk operations
+const (
+	bulkMaxIssues     = 200              // most issues one operation may change
+	bulkWorkers       = 8                // concurrent github requests per operation
+	bulkConfirmWindow = 10 * time.Minute // time allowed to confirm an operation
+	bulkMaxListed     = 20               // most issue numbers shown in a preview
+)
+
+// A bulk operation that is waiting for confirmation or has been carried
+// out.  Completed operations are kept as an undo record until the undo
+// window passes.
+type bulkOp struct {
+	token     string
+	user      teamKey
+	agent     *github.Agent
+	action    string
+	arg       string
+	display   string
+	milestone int
+	issues    []*github.Issue
+	created   time.Time
+	done      bool
+	undone    bool
+	failed    map[int]error
+	applied   map[int]time.Time // when github last updated each changed issue
+}
+
+// Arguments of the bulk subcommands
+var bulkArgs = &schema{
+	subs: []*schema{
+		{name: "close", args: []*argSpec{queryArg}},
+		{name: "label", args: []*argSpec{{name: "LABEL"}, queryArg}},
+		{name: "assign", args: []*argSpec{{name: "USER", kind: argUser}, queryArg}},
+		{name: "milestone", args: []*argSpec{{name: "MILESTONE"}, queryArg}},
+		{name: "confirm", args: []*argSpec{{name: "TOKEN"}}},
+		{name: "undo", args: []*argSpec{{name: "TOKEN"}}},
+	},
+	note: `QUERY is either github search terms (e.g. "label:bug is:open crash")
+or a list of KEY=VALUE issue list parameters (e.g. "state=open labels=bug").
+Pull requests are never matched`,
+}
+
+var queryArg = &argSpec{name: "QUERY", kind: argQuery, rest: true}
+
+func bulkCommand(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
+	log := log.WithField("method", "bulkCommand")
+	msg := ""
+	defer func(){w.Write([]byte(msg))}()
+
+	if _, err := getField("user_id", r); err != nil {
+		reqErr(log, w, err)
+		msg = ""
+		return
+	}
+	user := requester(r)
+
+	switch a.sub {
+	case "confirm":
+		msg = b.bulkConfirm(r, user, a.Get("TOKEN"))
+	case "undo":
+		msg = b.bulkUndo(r, user, a.Get("TOKEN"))
+	case "close":
+		msg = b.bulkPreview(r, user, &bulkOp{action: "close"}, a)
+	case "label":
+		msg = b.bulkPreview(r, user, &bulkOp{action: "label", arg: a.Get("LABEL")}, a)
+	case "assign":
+		msg = b.bulkPreview(r, user, &bulkOp{action: "assign", arg: a.Get("USER")}, a)
+	case "milestone":
+		msg = b.bulkPreview(r, user, &bulkOp{action: "milestone", arg: a.Get("MILESTONE")}, a)
+	}
+}
+
+// Run the query for a bulk operation, work out which issues it would
+// change and save it to be confirmed.  Returns the preview message.
+func (b *IssueBot) bulkPreview(r *http.Request, user teamKey, op *bulkOp, a *Args) string {
+	log := log.WithField("method", "bulkPreview")
+
+	b.Lock()
+	// bulk operations keep their own undo record
+	agent := b.teamAgent(r).WithSnapshots(nil)
+	b.Unlock()
+	op.agent = agent
+	op.display = op.arg
+	if op.action == "assign" {
+		gname, name, err := b.resolveUser(r, op.arg)
+		if err != nil {
+			return err.Error()
+		}
+		op.arg, op.display = gname, name
+	}
+
+	if op.action == "milestone" {
+		num, err := findMilestone(agent, op.arg)
+		if err != nil {
+			return err.Error()
+		}
+		op.milestone = num
+	}
+
+	query := a.Text("QUERY")
+	issues, err := bulkQuery(agent, a.List("QUERY"), query)
+	if err != nil {
+		log.Info("Bulk query failed: ", err)
+		return fmt.Sprintf("Unable to run query %q", query)
+	}
+	for _, iss := range issues {
+		if op.changes(iss) {
+			op.issues = append(op.issues, iss)
+		}
+	}
+	if len(op.issues) == 0 {
+		return fmt.Sprintf("No issues matching %q need to change", query)
+	}
+	if len(op.issues) > bulkMaxIssues {
+		return fmt.Sprintf("The query matches %d issues which is more than the limit of %d",
+			len(op.issues), bulkMaxIssues)
+	}
+	sort.Slice(op.issues, func(i, j int) bool {
+		return op.issues[i].Number < op.issues[j].Number
+	})
+
+	op.token = newToken()
+	op.user = user
+	op.created = time.Now()
+
+	b.Lock()
+	b.pruneBulk()
+	b.bulk[op.token] = op
+	b.Unlock()
+
+	return fmt.Sprintf("This will %s %d issues: %s\nConfirm with `/issue bulk confirm %s` within %s",
+		op.describe(), len(op.issues), listIssueNums(op.issues, bulkMaxListed),
+		op.token, bulkConfirmWindow)
+}
+
+// Carry out a previewed bulk operation.
+func (b *IssueBot) bulkConfirm(r *http.Request, user teamKey, token string) string {
+	b.Lock()
+	op, ok := b.bulk[token]
+	if !ok || op.user != user || op.done || time.Since(op.created) > bulkConfirmWindow {
+		b.Unlock()
+		return fmt.Sprintf("No pending bulk operation %q", token)
+	}
+	op.done = true
+	op.applied = make(map[int]time.Time)
+	// the agent was made for the preview so changes need to be added to
+	// this request's audit record.  Its snapshots record when each issue
+	// was changed so that undo can tell if anyone has changed it since.
+	agent := auditAgent(r, op.agent.WithSnapshots(func(snap *github.Snapshot) {
+		b.Lock()
+		op.applied[snap.Issue] = snap.UpdatedAt
+		b.Unlock()
+	}))
+	window := time.Duration(b.config.UndoWindow)
+	b.Unlock()
+
+	failed := runBulk(op.issues, func(iss *github.Issue) error {
+		return op.apply(agent, iss)
+	})
+
+	b.Lock()
+	op.failed = failed
+	b.Unlock()
+
+	msg := fmt.Sprintf("Changed %d of %d issues", len(op.issues)-len(failed), len(op.issues))
+	msg += reportFailures(failed)
+	if len(failed) < len(op.issues) {
+		msg += fmt.Sprintf("\nUndo with `/issue bulk undo %s` within %s", token, window)
+	}
+	return msg
+}
+
+// Revert a completed bulk operation using the issue state saved when it
+// was previewed.  Issues that anyone has changed since the operation are
+// skipped:  reverting them could silently throw away someone else's work.
+func (b *IssueBot) bulkUndo(r *http.Request, user teamKey, token string) string {
+	b.Lock()
+	op, ok := b.bulk[token]
+	if !ok || op.user != user || !op.done || op.undone ||
+		time.Since(op.created) > time.Duration(b.config.UndoWindow) {
+		b.Unlock()
+		return fmt.Sprintf("No bulk operation %q to undo", token)
+	}
+	op.undone = true
+	agent := auditAgent(r, op.agent)
+	var changed []*github.Issue
+	applied := make(map[int]time.Time)
+	for _, iss := range op.issues {
+		if _, ok := op.failed[iss.Number]; !ok {
+			changed = append(changed, iss)
+			applied[iss.Number] = op.applied[iss.Number]
+		}
+	}
+	b.Unlock()
+
+	skipped := runBulk(changed, func(iss *github.Issue) error {
+		cur, err := agent.GetIssue(iss.Number)
+		if err != nil {
+			return fmt.Errorf("unable to read the issue")
+		}
+		if !cur.UpdatedAt.Equal(applied[iss.Number]) {
+			return fmt.Errorf("changed again at %s", cur.UpdatedAt.Format(time.RFC1123))
+		}
+		return nil
+	})
+	var revert []*github.Issue
+	for _, iss := range changed {
+		if _, ok := skipped[iss.Number]; !ok {
+			revert = append(revert, iss)
+		}
+	}
+	failed := runBulk(revert, func(iss *github.Issue) error {
+		return op.revert(agent, iss)
+	})
+	msg := fmt.Sprintf("Reverted %d of %d issues", len(revert)-len(failed), len(changed))
+	msg += reportIssues("Skipped", skipped)
+	return msg + reportFailures(failed)
+}
+
+// Drop expired bulk operations.  Must be called with the lock held.
+func (b *IssueBot) pruneBulk() {
+	window := time.Duration(b.config.UndoWindow)
+	for token, op := range b.bulk {
+		if time.Since(op.created) > window || (!op.done && time.Since(op.created) > bulkConfirmWindow) {
+			delete(b.bulk, token)
+		}
+	}
+}
+
+// Returns true if the operation would change the issue.
+func (op *bulkOp) changes(iss *github.Issue) bool {
+	switch op.action {
+	case "close":
+		return iss.State != "closed"
+	case "label":
+		for _, l := range iss.Labels {
+			if strings.EqualFold(l.Name, op.arg) {
+				return false
+			}
+		}
+		return true
+	case "assign":
+		logins := assigneeLogins(iss)
+		return len(logins) != 1 || !strings.EqualFold(logins[0], op.arg)
+	case "milestone":
+		return iss.Milestone == nil || iss.Milestone.Number != op.milestone
+	}
+	return false
+}
+
+// Describe the operation for a preview.
+func (op *bulkOp) describe() string {
+	switch op.action {
+	case "label":
+		return fmt.Sprintf("add label %q to", op.arg)
+	case "assign":
+		return fmt.Sprintf("assign %s to", op.display)
+	case "milestone":
+		return fmt.Sprintf("set milestone %q on", op.arg)
+	}
+	return op.action
+}
+
+func (op *bulkOp) apply(a *github.Agent, iss *github.Issue) error {
+	switch op.action {
+	case "close":
+		return a.CloseIssue(iss.Number)
+	case "label":
+		return a.LabelIssue(iss.Number, op.arg)
+	case "assign":
+		return a.AssignIssue(iss.Number, op.arg)
+	case "milestone":
+		return a.SetMilestone(iss.Number, op.milestone)
+	}
+	return fmt.Errorf("unknown bulk action %q", op.action)
+}
+
+// Restore an issue to the state it was in before the operation.
+func (op *bulkOp) revert(a *github.Agent, iss *github.Issue) error {
+	switch op.action {
+	case "close":
+		return a.OpenIssue(iss.Number)
+	case "label":
+		return a.UnlabelIssue(iss.Number, op.arg)
+	case "assign":
+		return a.SetAssignees(iss.Number, assigneeLogins(iss))
+	case "milestone":
+		m := 0
+		if iss.Milestone != nil {
+			m = iss.Milestone.Number
+		}
+		return a.SetMilestone(iss.Number, m)
+	}
+	return fmt.Errorf("unknown bulk action %q", op.action)
+}
+
+// Run a bulk query.  If every word is of the form KEY=VALUE they are
+// used as issue list parameters.  Otherwise the query is a github search
+// passed on as typed.  Pull requests are never included.
+func bulkQuery(a *github.Agent, words []string, query string) ([]*github.Issue, error) {
+	params := make(map[string]string)
+	for _, q := range words {
+		kv := strings.SplitN(q, "=", 2)
+		if len(kv) != 2 {
+			return a.Search(query)
+		}
+		params[kv[0]] = kv[1]
+	}
+	list, err := a.FetchIssues(params)
+	if err != nil {
+		return nil, err
+	}
+	issues := []*github.Issue{}
+	for _, iss := range list {
+		if !iss.IsPullRequest() {
+			issues = append(issues, iss)
+		}
+	}
+	return issues, nil
+}
+
+// Find a milestone by number or title.
+func findMilestone(a *github.Agent, name string) (int, error) {
+	milestones, err := a.FetchMilestones()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to read milestones")
+	}
+	num, _ := strconv.Atoi(name)
+	for _, m := range milestones {
+		if m.Number == num || strings.EqualFold(m.Title, name) {
+			return m.Number, nil
+		}
+	}
+	return 0, fmt.Errorf("No milestone named %q", name)
+}
+
+// Apply f to every issue with at most bulkWorkers running at once.
+// Returns the errors for the issues that failed by issue number.
+func runBulk(issues []*github.Issue, f func(*github.Issue) error) map[int]error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := make(map[int]error)
+	sem := make(chan struct{}, bulkWorkers)
+	for _, iss := range issues {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(iss *github.Issue) {
+			defer wg.Done()
+			if err := f(iss); err != nil {
+				mu.Lock()
+				failed[iss.Number] = err
+				mu.Unlock()
+			}
+			<-sem
+		}(iss)
+	}
+	wg.Wait()
+	return failed
+}
+
+func reportFailures(failed map[int]error) string {
+	return reportIssues("Failed", failed)
+}
+
+// List the issues in m by number under a heading along with why.
+func reportIssues(heading string, m map[int]error) string {
+	if len(m) == 0 {
+		return ""
+	}
+	var nums []int
+	for n := range m {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	msg := "\n" + heading + ":"
+	for _, n := range nums {
+		msg += fmt.Sprintf("\n\t%d: %s", n, m[n])
+	}
+	return msg
+}
+
+// Return a comma separated list of at most max issue numbers.
+func listIssueNums(issues []*github.Issue, max int) string {
+	var nums []string
+	for i, iss := range issues {
+		if i == max {
+			nums = append(nums, fmt.Sprintf("and %d more", len(issues)-max))
+			break
+		}
+		nums = append(nums, strconv.Itoa(iss.Number))
+	}
+	return strings.Join(nums, ", ")
+}
+
+func assigneeLogins(iss *github.Issue) []string {
+	logins := []string{}
+	for _, u := range iss.Assignees {
+		logins = append(logins, u.Login)
+	}
+	if len(logins) == 0 && iss.Assignee != nil {
+		logins = append(logins, iss.Assignee.Login)
+	}
+	return logins
+}
+
+// Generate a short random token to identify an operation.
+func newToken() string {
+	var buf [4]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf[:])
+}
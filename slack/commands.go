@@ -0,0 +1,287 @@
+package slack
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// CommandFunc handles an /issue command.  It writes its reply to w as
+// plain text or as a JSON message.  a holds the command's arguments,
+// already checked against its usage.
+type CommandFunc func(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args)
+
+// CommandSpec describes a command added with RegisterCommand().
+type CommandSpec struct {
+	// One line description listed by '/issue help'
+	Summary string
+
+	// The command's options and arguments, e.g. "[-f|--force] NUM
+	// [TEXT...]".  Options are written [--name], [--name=VALUE] or
+	// [-n|--name].  Arguments in [...] are optional and an argument
+	// ending in "..." takes the remaining words as free text.  Arguments
+	// named NUM or ISSUE must be issue numbers and those named USER must
+	// not be channels or links.
+	Usage string
+
+	// More about the command shown by '/issue help CMD'
+	Details string
+
+	// Other names for the command
+	Aliases []string
+
+	// The role needed to run the command:  "reporter", "triager",
+	// "maintainer", "admin" or "" to let anyone run it.
+	Role string
+
+	// Set if the command may take longer than the 3 seconds slack waits
+	// for a reply, e.g. because it talks to github.  The command is then
+	// acknowledged straight away and its reply is sent when it finishes.
+	Async bool
+}
+
+// An /issue command
+type command struct {
+	name    string
+	run     CommandFunc
+	role    role
+	args    *schema
+	summary string
+	details string
+	aliases []string
+	async   bool
+}
+
+// Most suggestions offered for a mistyped command
+const maxSuggestions = 3
+
+// Add the command '/issue NAME' to the bot.  Its arguments are checked
+// against spec.Usage before run is called, and '/issue help' lists it.
+// This must be called before Run().
+func (b *IssueBot) RegisterCommand(name string, spec CommandSpec, run CommandFunc) error {
+	ro, ok := roleNames[spec.Role]
+	if !ok && spec.Role != "" {
+		return fmt.Errorf("command %s: unknown role %q", name, spec.Role)
+	}
+	args, err := parseUsage(spec.Usage)
+	if err != nil {
+		return fmt.Errorf("command %s: %v", name, err)
+	}
+	cmd := &command{
+		name:    name,
+		run:     run,
+		role:    ro,
+		args:    args,
+		summary: spec.Summary,
+		details: spec.Details,
+		aliases: spec.Aliases,
+		async:   spec.Async,
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	for _, n := range append([]string{name}, spec.Aliases...) {
+		if n == "" || strings.IndexFunc(n, unicode.IsSpace) >= 0 {
+			return fmt.Errorf("invalid command name %q", n)
+		}
+		if _, ok := b.commands[n]; ok {
+			return fmt.Errorf("command %q already exists", n)
+		}
+	}
+	b.addCommand(cmd)
+	return nil
+}
+
+// Add a command under its name and aliases.  Must be called with the
+// lock held.
+func (b *IssueBot) addCommand(cmd *command) {
+	b.cmdList = append(b.cmdList, cmd)
+	b.commands[cmd.name] = cmd
+	for _, alias := range cmd.aliases {
+		b.commands[alias] = cmd
+	}
+}
+
+// Return a handler that runs the command if the user who sent the
+// request has the command's role.  what describes the command for the
+// denial.
+func (cmd *command) guard(what string) CommandFunc {
+	if cmd.role == roleNone {
+		return cmd.run
+	}
+	return func(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
+		if err := b.authorize(r, cmd.role, what); err != nil {
+			w.Write([]byte(err.Error()))
+			return
+		}
+		cmd.run(b, w, r, a)
+	}
+}
+
+// /issue help [CMD [SUBCOMMAND]]
+func help(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
+	words := a.List("CMD")
+	if len(words) == 0 {
+		w.Write([]byte(b.commandList(r)))
+		return
+	}
+	b.Lock()
+	cmd, ok := b.commands[words[0]]
+	exp, isAlias := b.alias(r, words[0])
+	b.Unlock()
+	switch {
+	case ok:
+		w.Write([]byte(cmd.help(words[1:])))
+	case isAlias:
+		w.Write([]byte(aliasUsage(words[0], exp)))
+	default:
+		w.Write([]byte(b.unknownCommand(r, words[0])))
+	}
+}
+
+// Return the list of commands and aliases that '/issue help' shows.
+func (b *IssueBot) commandList(r *http.Request) string {
+	b.Lock()
+	cmds := b.cmdList
+	aliases := b.teamAliases(r)
+	b.Unlock()
+	msg := "usage: /issue CMD [params]\nCommands:\n"
+	for _, cmd := range cmds {
+		msg += "\t" + cmd.args.synopsis(cmd.name)
+		if cmd.summary != "" {
+			msg += " - " + cmd.summary
+		}
+		msg += "\n"
+	}
+	if len(aliases) > 0 {
+		msg += "Aliases:\n"
+		for _, name := range aliasNames(aliases) {
+			msg += fmt.Sprintf("\t%s - `%s`\n", aliasSynopsis(name, aliases[name]), aliases[name])
+		}
+	}
+	return msg + "Run '/issue help CMD' for more about a command.  Separate several commands with ';'."
+}
+
+// Return the detailed usage of a command or of one of its subcommands.
+func (cmd *command) help(subs []string) string {
+	s, path := cmd.args, cmd.name
+	for _, name := range subs {
+		sub := s.sub(name)
+		if sub == nil {
+			return s.unknownSub(name, path).Error()
+		}
+		s, path = sub, path+" "+sub.name
+	}
+	msg := "/issue " + cmd.name
+	if cmd.summary != "" {
+		msg += " - " + cmd.summary
+	}
+	msg += "\n" + s.usage(path)
+	if cmd.details != "" {
+		msg += "\n" + cmd.details
+	}
+	if len(cmd.aliases) > 0 {
+		msg += "\nAlso run as: /issue " + strings.Join(cmd.aliases, ", /issue ")
+	}
+	if cmd.role != roleNone {
+		msg += fmt.Sprintf("\nNeeds the %s role or higher", cmd.role)
+	}
+	return msg
+}
+
+// Return the reply to a command that is neither one of the bot's nor an
+// alias in the workspace that sent a request.
+func (b *IssueBot) unknownCommand(r *http.Request, name string) string {
+	b.Lock()
+	var names []string
+	for n := range b.commands {
+		names = append(names, n)
+	}
+	names = append(names, aliasNames(b.teamAliases(r))...)
+	b.Unlock()
+	msg := fmt.Sprintf("unknown command %q", name)
+	if s := suggest(name, names); len(s) > 0 {
+		return msg + ", " + didYouMean(s)
+	}
+	return msg + "\nRun '/issue help' for the list of commands"
+}
+
+// Return the names that a mistyped name was probably meant to be:  those
+// that start with it and those within a typo or two of it.  The closest
+// come first.
+func suggest(name string, names []string) []string {
+	maxDist := 1
+	if len(name) > 4 {
+		maxDist = 2
+	}
+	dist := make(map[string]int)
+	var found []string
+	for _, n := range names {
+		d := editDistance(name, n)
+		if len(name) > 1 && strings.HasPrefix(n, name) {
+			d = 0
+		}
+		if d <= maxDist {
+			dist[n] = d
+			found = append(found, n)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if dist[found[i]] != dist[found[j]] {
+			return dist[found[i]] < dist[found[j]]
+		}
+		return found[i] < found[j]
+	})
+	if len(found) > maxSuggestions {
+		found = found[:maxSuggestions]
+	}
+	return found
+}
+
+// Return e.g. `did you mean "close" or "clone"?`.
+func didYouMean(names []string) string {
+	var quoted []string
+	for _, n := range names {
+		quoted = append(quoted, fmt.Sprintf("%q", n))
+	}
+	last := len(quoted) - 1
+	if last == 0 {
+		return "did you mean " + quoted[0] + "?"
+	}
+	return "did you mean " + strings.Join(quoted[:last], ", ") + " or " + quoted[last] + "?"
+}
+
+// Return the number of single character insertions, deletions and
+// substitutions that turn a into b.
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
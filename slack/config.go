@@ -0,0 +1,286 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the issuebot settings that are read from a JSON config
+// file rather than the command line.  For example:
+//
+//	{
+//	    "admins": ["U0ALICE00"],
+//	    "roles": {"maintainer": ["U0123ABCD"], "triager": ["S0123ABCD"]},
+//	    "github_roles": true,
+//	    "repos": ["owner/docs"],
+//	    "workspaces": {
+//	        "T0123ABCD": {"repo": "owner/other", "admins": ["U0BOB0000"]}
+//	    },
+//	    "responses": {"find": "in_channel"},
+//	    "channels": {
+//	        "C0123ABCD": {"responses": {"*": "ephemeral"}}
+//	    },
+//	    "undo_window": "30m",
+//	    "aliases": {
+//	        "triage": "label $1 +triaged; assign $1 @me",
+//	        "mine": "grep assignee:$me state:open"
+//	    },
+//	    "sweep_interval": "6h",
+//	    "sweep": [
+//	        {
+//	            "repo": "owner/repo",
+//	            "stale_after": "60d",
+//	            "close_after": "7d",
+//	            "exempt_labels": ["pinned", "security"]
+//	        }
+//	    ]
+//	}
+type Config struct {
+	// Slack user IDs allowed to run '/issue admin' commands.  The same
+	// as listing them under "admin" in Roles.
+	Admins []string `json:"admins"`
+
+	// Slack users given each role:  "admin", "maintainer", "triager" or
+	// "reporter".  Users are given by ID since anyone can take a user
+	// name, and user group IDs give the role to every member of the
+	// group.
+	Roles map[string][]string `json:"roles"`
+
+	// Role of users who aren't given one.  Defaults to "reporter".
+	DefaultRole string `json:"default_role"`
+
+	// Give registered users the role matching their permission on the
+	// github repository if it is higher than their slack role.
+	GithubRoles bool `json:"github_roles"`
+
+	// Other repositories that users may file new issues in
+	Repos []string `json:"repos"`
+
+	// Settings for individual slack workspaces by team ID
+	Workspaces map[string]*WorkspaceConfig `json:"workspaces"`
+
+	// How to reply to each command:  "in_channel" to show the reply to
+	// everyone in the channel or "ephemeral" to show it only to the user
+	// who ran the command.  "*" sets the default for all commands.
+	Responses map[string]string `json:"responses"`
+
+	// Settings for particular channels by channel ID or name
+	Channels map[string]*ChannelConfig `json:"channels"`
+
+	// How long users have to undo a change with '/issue undo'.  Defaults
+	// to an hour.
+	UndoWindow Duration `json:"undo_window"`
+
+	// Commands that run other commands.  Each is one or more commands
+	// separated by ';' in which $1 to $9 stand for the alias's arguments,
+	// $* for all of them and $me for the github name of the user.
+	// Aliases defined with '/issue admin alias' take precedence.
+	Aliases map[string]string `json:"aliases"`
+
+	// How often to run the stale issue sweeper
+	SweepInterval Duration `json:"sweep_interval"`
+
+	// Stale issue policies, at most one per repository
+	Sweep []*SweepPolicy `json:"sweep"`
+}
+
+// WorkspaceConfig overrides settings for slash commands from one slack
+// workspace.
+type WorkspaceConfig struct {
+	// Repository to manage.  Defaults to the bot's repository.
+	Repo string `json:"repo"`
+
+	// Slack user IDs in this workspace allowed to run '/issue admin'
+	// commands.  Defaults to the global admins.
+	Admins []string `json:"admins"`
+
+	// Roles of slack users in this workspace.  Defaults to the global
+	// roles.
+	Roles map[string][]string `json:"roles"`
+}
+
+// ChannelConfig overrides settings for slash commands run in one channel.
+type ChannelConfig struct {
+	// Overrides the global responses for this channel
+	Responses map[string]string `json:"responses"`
+}
+
+// SweepPolicy controls how the stale issue sweeper treats one repository.
+type SweepPolicy struct {
+	// Repository to sweep.  Defaults to the bot's repository.
+	Repo string `json:"repo"`
+
+	// Mark open issues stale when they haven't been updated for this long
+	StaleAfter Duration `json:"stale_after"`
+
+	// Close stale issues when they stay inactive this long after marking
+	CloseAfter Duration `json:"close_after"`
+
+	// Label used to mark stale issues.  Defaults to "stale".
+	Label string `json:"label"`
+
+	// Issues with any of these labels are never marked stale
+	ExemptLabels []string `json:"exempt_labels"`
+
+	// Comments posted when marking and closing stale issues
+	Warning      string `json:"warning"`
+	CloseComment string `json:"close_comment"`
+}
+
+// Defaults for config settings
+const (
+	defSweepInterval = 6 * time.Hour
+	defUndoWindow    = time.Hour
+	defRole          = "reporter"
+	defStaleLabel    = "stale"
+	defStaleWarning  = "This issue has been automatically marked as stale because it has " +
+		"not had any activity for %s.  It will be closed in %s unless there is further activity."
+	defCloseComment = "Closing this issue because it has been inactive since it was marked stale."
+)
+
+// Duration is a time.Duration that is written in JSON as a string such
+// as "90m" or "36h".  It also accepts a number of days such as "30d".
+type Duration time.Duration
+
+// Implement json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Implement json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Format a duration in days if it is a whole number of days.
+func fmtDuration(d time.Duration) string {
+	day := 24 * time.Hour
+	if d >= day && d%day == 0 {
+		return fmt.Sprintf("%d days", d/day)
+	}
+	return d.String()
+}
+
+// Read a config file.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg := &Config{}
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return cfg, nil
+}
+
+// Fill in defaults and check the config for errors.  The default repo
+// is used for sweep policies that don't name one.
+func (cfg *Config) validate(repo string) error {
+	if cfg.SweepInterval == 0 {
+		cfg.SweepInterval = Duration(defSweepInterval)
+	}
+	if cfg.UndoWindow == 0 {
+		cfg.UndoWindow = Duration(defUndoWindow)
+	}
+	if err := checkResponses(cfg.Responses); err != nil {
+		return err
+	}
+	for name, ch := range cfg.Channels {
+		if err := checkResponses(ch.Responses); err != nil {
+			return fmt.Errorf("channel %s: %s", name, err)
+		}
+	}
+	if cfg.DefaultRole == "" {
+		cfg.DefaultRole = defRole
+	}
+	if _, ok := roleNames[cfg.DefaultRole]; !ok {
+		return fmt.Errorf("unknown default role %q", cfg.DefaultRole)
+	}
+	if err := checkRoles(cfg.Roles); err != nil {
+		return err
+	}
+	global := cfg.Roles
+	cfg.Roles = withAdmins(global, cfg.Admins)
+	if err := checkMembers(cfg.Roles); err != nil {
+		return err
+	}
+	for team, ws := range cfg.Workspaces {
+		if ws.Repo == "" {
+			ws.Repo = repo
+		}
+		if ws.Admins == nil {
+			ws.Admins = cfg.Admins
+		}
+		if ws.Roles == nil {
+			ws.Roles = global
+		}
+		if err := checkRoles(ws.Roles); err != nil {
+			return fmt.Errorf("workspace %s: %s", team, err)
+		}
+		ws.Roles = withAdmins(ws.Roles, ws.Admins)
+		if err := checkMembers(ws.Roles); err != nil {
+			return fmt.Errorf("workspace %s: %s", team, err)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, p := range cfg.Sweep {
+		if p.Repo == "" {
+			p.Repo = repo
+		}
+		if seen[p.Repo] {
+			return fmt.Errorf("multiple sweep policies for %s", p.Repo)
+		}
+		seen[p.Repo] = true
+		if p.StaleAfter <= 0 || p.CloseAfter <= 0 {
+			return fmt.Errorf("sweep policy for %s needs stale_after and close_after", p.Repo)
+		}
+		if p.Label == "" {
+			p.Label = defStaleLabel
+		}
+		if p.Warning == "" {
+			p.Warning = fmt.Sprintf(defStaleWarning,
+				fmtDuration(time.Duration(p.StaleAfter)), fmtDuration(time.Duration(p.CloseAfter)))
+		}
+		if p.CloseComment == "" {
+			p.CloseComment = defCloseComment
+		}
+	}
+	return nil
+}
+
+func checkResponses(responses map[string]string) error {
+	for cmd, rt := range responses {
+		if rt != inChannel && rt != ephemeral {
+			return fmt.Errorf("response for %q must be %q or %q", cmd, inChannel, ephemeral)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ctelfer-docker/slkiss/github"
+)
+
+// Limits on replying to issue references in messages
+const (
+	maxRefsPerMessage = 3               // most issues replied to per message
+	autolinkBurst     = 5               // most replies per channel...
+	autolinkWindow    = 5 * time.Minute // ...in this long
+	eventDedupWindow  = 10 * time.Minute
+)
+
+// The outer envelope of an Events API request.  See:
+//   https://api.slack.com/apis/connections/events-api
+type eventEnvelope struct {
+	Token     string          `json:"token"`
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	TeamID    string          `json:"team_id"`
+	EventID   string          `json:"event_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// The fields of the events the bot handles
+type event struct {
+	Type      string `json:"type"`
+	Subtype   string `json:"subtype"`
+	Channel   string `json:"channel"`
+	User      string `json:"user"`
+	BotID     string `json:"bot_id"`
+	Text      string `json:"text"`
+	TS        string `json:"ts"`
+	ThreadTS  string `json:"thread_ts"`
+	MessageTS string `json:"message_ts"`
+	Links     []struct {
+		Domain string `json:"domain"`
+		URL    string `json:"url"`
+	} `json:"links"`
+}
+
+// Handlers for events by type
+var eventHandlers = map[string]func(*IssueBot, *http.Request, *event){
+	"link_shared": linkShared,
+	"message":     messageEvent,
+}
+
+// Matches a github issue URL
+var issueURLRe = regexp.MustCompile(`^https://github\.com/([\w.-]+/[\w.-]+)/issues/(\d+)`)
+
+// Matches an issue reference such as #12, repo#12 or owner/repo#12
+var issueRefRe = regexp.MustCompile(`(?:^|[\s(\[])((?:[\w.-]+/)?[\w.-]+)?#(\d+)\b`)
+
+// Parts of a message in which issue references are ignored:  links,
+// mentions and code
+var noRefsRe = regexp.MustCompile("<[^>]*>|```[^`]*```|`[^`]*`")
+
+// Handle requests to /slack/events.
+func (b *IssueBot) serveEvents(w http.ResponseWriter, r *http.Request) {
+	log := log.WithField("method", "serveEvents")
+	if err := b.verifier.verifySignature(r); err != nil {
+		verifyErr(w, r, err)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err != nil {
+		reqErr(log, w, err)
+		return
+	}
+	var env eventEnvelope
+	if err = json.Unmarshal(body, &env); err != nil {
+		http.Error(w, "malformed event", http.StatusBadRequest)
+		log.Warn("Error processing event: ", err)
+		return
+	}
+	if err = b.verifier.checkToken(env.Token); err != nil {
+		verifyErr(w, r, err)
+		return
+	}
+
+	switch env.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(env.Challenge))
+	case "event_callback":
+		w.WriteHeader(http.StatusOK)
+		b.dispatchEvent(r, &env)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// Run the handler for an event in the background.  Events that slack
+// sends more than once are only handled the first time.
+func (b *IssueBot) dispatchEvent(r *http.Request, env *eventEnvelope) {
+	log := log.WithField("method", "dispatchEvent")
+	if !b.installed(env.TeamID) || b.seenEvent(env.EventID) {
+		return
+	}
+	var ev event
+	if err := json.Unmarshal(env.Event, &ev); err != nil {
+		log.Warn("Error processing event: ", err)
+		return
+	}
+	h, ok := eventHandlers[ev.Type]
+	if !ok {
+		log.Debug("Ignoring event of type ", ev.Type)
+		return
+	}
+
+	// Fill in the form fields that a slash command would carry so that
+	// helpers such as teamRepo() work on events too.
+	r.PostForm = url.Values{
+		"team_id":    {env.TeamID},
+		"user_id":    {ev.User},
+		"channel_id": {ev.Channel},
+	}
+
+	select {
+	case b.jobs <- struct{}{}:
+	default:
+		log.Warn("Too busy to handle ", ev.Type, " event")
+		return
+	}
+	go func() {
+		defer func() { <-b.jobs }()
+		h(b, r, &ev)
+	}()
+}
+
+// Returns true if an event has already been handled and records it
+// otherwise.
+func (b *IssueBot) seenEvent(id string) bool {
+	if id == "" {
+		return false
+	}
+	b.Lock()
+	defer b.Unlock()
+	for eid, t := range b.events {
+		if time.Since(t) > eventDedupWindow {
+			delete(b.events, eid)
+		}
+	}
+	if _, ok := b.events[id]; ok {
+		return true
+	}
+	b.events[id] = time.Now()
+	return false
+}
+
+// Unfurl links to issues in the repositories the bot manages.
+func linkShared(b *IssueBot, r *http.Request, ev *event) {
+	log := log.WithField("method", "linkShared")
+	unfurls := make(map[string]*attachment)
+	for _, l := range ev.Links {
+		m := issueURLRe.FindStringSubmatch(l.URL)
+		if m == nil {
+			continue
+		}
+		a := b.managedAgent(r, m[1])
+		if a == nil {
+			continue
+		}
+		num, _ := strconv.Atoi(m[2])
+		iss, err := a.GetIssue(num)
+		if err != nil {
+			log.Info("Unable to unfurl ", l.URL, ": ", err)
+			continue
+		}
+		unfurls[l.URL] = &attachment{Color: stateColors[iss.State], Blocks: b.issueSummary(r, a.Repo(), iss)}
+	}
+	if len(unfurls) == 0 {
+		return
+	}
+	b.Lock()
+	api := b.teamAPI(r.PostForm.Get("team_id"))
+	b.Unlock()
+	if err := api.Unfurl(ev.Channel, ev.MessageTS, unfurls); err != nil {
+		log.Warn("Unable to unfurl links: ", err)
+	}
+}
+
+// Reply in thread to messages that mention issues by number.
+func messageEvent(b *IssueBot, r *http.Request, ev *event) {
+	log := log.WithField("method", "messageEvent")
+	if ev.Subtype != "" || ev.BotID != "" || ev.Text == "" {
+		return
+	}
+
+	b.Lock()
+	def := b.teamRepo(r)
+	api := b.teamAPI(r.PostForm.Get("team_id"))
+	b.Unlock()
+
+	seen := make(map[string]bool)
+	text := noRefsRe.ReplaceAllString(ev.Text, " ")
+	for _, m := range issueRefRe.FindAllStringSubmatch(text, -1) {
+		if len(seen) == maxRefsPerMessage {
+			break
+		}
+		repo := m[1]
+		if repo == "" {
+			repo = def
+		}
+		a := b.managedAgent(r, repo)
+		num, _ := strconv.Atoi(m[2])
+		if a == nil || seen[a.Repo()+"#"+m[2]] {
+			continue
+		}
+		seen[a.Repo()+"#"+m[2]] = true
+		if !b.allowAutolink(ev.Channel) {
+			log.Debug("Not replying to issue reference in ", ev.Channel, ":  rate limited")
+			return
+		}
+
+		iss, err := a.GetIssue(num)
+		if err != nil {
+			log.Debug("Unable to find referenced issue ", a.Repo(), "#", num, ": ", err)
+			continue
+		}
+		thread := ev.ThreadTS
+		if thread == "" {
+			thread = ev.TS
+		}
+		msg := newResponse(fmt.Sprintf("%s#%d: %s", a.Repo(), num, iss.Title))
+		msg.addColored(stateColors[iss.State], b.issueSummary(r, a.Repo(), iss)...)
+		if _, err = api.PostMessage(msg.message(ev.Channel, thread)); err != nil {
+			log.Warn("Unable to reply to issue reference: ", err)
+		}
+	}
+}
+
+// Return an agent for a repository named by a link or reference if it is
+// one that users in the workspace that sent the request may use.  The
+// name may leave out the owner.
+func (b *IssueBot) managedAgent(r *http.Request, name string) *github.Agent {
+	b.Lock()
+	defer b.Unlock()
+	for _, repo := range b.teamRepos(r) {
+		if strings.EqualFold(repo, name) || strings.EqualFold(repo[strings.Index(repo, "/")+1:], name) {
+			return b.repoAgent(repo)
+		}
+	}
+	return nil
+}
+
+// Returns true if the bot may reply to another issue reference in a
+// channel.
+func (b *IssueBot) allowAutolink(channel string) bool {
+	b.Lock()
+	defer b.Unlock()
+	var recent []time.Time
+	for _, t := range b.linked[channel] {
+		if time.Since(t) < autolinkWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= autolinkBurst {
+		b.linked[channel] = recent
+		return false
+	}
+	b.linked[channel] = append(recent, time.Now())
+	return true
+}
+
+// Build a short summary of an issue for unfurls and replies.
+func (b *IssueBot) issueSummary(r *http.Request, repo string, iss *github.Issue) []*block {
+	who := "Unassigned"
+	if names := b.displayNames(r, assigneeLogins(iss)); len(names) > 0 {
+		who = "Assigned to " + escape(strings.Join(names, ", "))
+	}
+	return []*block{
+		sectionBlock(fmt.Sprintf("%s *%s*",
+			link(iss.HTMLURL, fmt.Sprintf("%s#%d", repo, iss.Number)), escape(iss.Title))),
+		contextBlock(stateBadge(iss.State), who),
+	}
+}
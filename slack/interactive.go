@@ -0,0 +1,330 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/ctelfer-docker/slkiss/github"
+)
+
+// Action IDs of the issue triage buttons
+const (
+	actionClose    = "issue.close"
+	actionReopen   = "issue.reopen"
+	actionAssignMe = "issue.assign_me"
+	actionLabel    = "issue.label"
+)
+
+// The parts of an interaction payload that the bot uses.  See:
+//   https://api.slack.com/reference/interaction-payloads
+type interaction struct {
+	Type        string `json:"type"`
+	Token       string `json:"token"`
+	CallbackID  string `json:"callback_id"`
+	TriggerID   string `json:"trigger_id"`
+	ResponseURL string `json:"response_url"`
+	User        struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Team struct {
+		ID string `json:"id"`
+	} `json:"team"`
+	Channel struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"channel"`
+	Actions []*blockAction  `json:"actions"`
+	Message json.RawMessage `json:"message"`
+	View    *submittedView  `json:"view"`
+}
+
+// One action from a block_actions payload
+type blockAction struct {
+	ActionID       string  `json:"action_id"`
+	BlockID        string  `json:"block_id"`
+	Value          string  `json:"value"`
+	SelectedOption *option `json:"selected_option"`
+}
+
+// Handlers for interaction payloads by type
+var interactionHandlers = map[string]func(*IssueBot, http.ResponseWriter, *http.Request, *interaction){
+	"block_actions":   blockActions,
+	"view_submission": viewSubmission,
+	"message_action":  messageAction,
+}
+
+// A triage action on an issue.  Returns what was done to the issue for
+// the activity line, e.g. "closed".
+type issueAction func(b *IssueBot, r *http.Request, a *github.Agent, num int, act *blockAction) (string, error)
+
+var triageActions = map[string]issueAction{
+	actionClose:    closeAction,
+	actionReopen:   reopenAction,
+	actionAssignMe: assignMeAction,
+	actionLabel:    labelAction,
+}
+
+// Handle requests to /slack/interactive.  Slack sends these when users
+// click buttons, submit modals and so on.  The payload is a JSON document
+// in the "payload" form field.
+func (b *IssueBot) serveInteractive(w http.ResponseWriter, r *http.Request) {
+	log := log.WithField("method", "serveInteractive")
+	if err := b.verifier.verifySignature(r); err != nil {
+		verifyErr(w, r, err)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		reqErr(log, w, err)
+		return
+	}
+	var p interaction
+	if err := json.Unmarshal([]byte(r.PostForm.Get("payload")), &p); err != nil {
+		http.Error(w, "malformed payload", http.StatusBadRequest)
+		log.Warn("Error processing interaction: ", err)
+		return
+	}
+	if err := b.verifier.checkToken(p.Token); err != nil {
+		verifyErr(w, r, err)
+		return
+	}
+	if !b.installed(p.Team.ID) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Fill in the form fields that a slash command would carry so that
+	// helpers such as teamAgent() and actor() work on interactions too.
+	for k, v := range p.form() {
+		r.PostForm[k] = v
+	}
+
+	h, ok := interactionHandlers[p.Type]
+	if !ok {
+		log.Debug("Ignoring interaction of type ", p.Type)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	h(b, w, r, &p)
+}
+
+// Return the slash command form fields for an interaction.
+func (p *interaction) form() url.Values {
+	return url.Values{
+		"team_id":      {p.Team.ID},
+		"user_id":      {p.User.ID},
+		"user_name":    {p.User.Username},
+		"channel_id":   {p.Channel.ID},
+		"channel_name": {p.Channel.Name},
+		"response_url": {p.ResponseURL},
+	}
+}
+
+// Handle clicks on the issue triage buttons.  The click is acknowledged
+// straight away and the message is updated once github has been changed.
+func blockActions(b *IssueBot, w http.ResponseWriter, r *http.Request, p *interaction) {
+	log := log.WithField("method", "blockActions")
+	w.WriteHeader(http.StatusOK)
+	if len(p.Actions) > 0 && p.View != nil && p.View.CallbackID == newIssueCallback {
+		newIssueAction(b, r, p, p.Actions[0])
+		return
+	}
+	if len(p.Actions) == 0 || p.ResponseURL == "" {
+		return
+	}
+	act := p.Actions[0]
+	h, ok := triageActions[act.ActionID]
+	num, isIssue := blockIssue(act.BlockID)
+	if !ok || !isIssue {
+		log.Debug("Ignoring action ", act.ActionID)
+		return
+	}
+
+	select {
+	case b.jobs <- struct{}{}:
+	default:
+		postResponse(p.ResponseURL, ephemeralMessage(busyMessage))
+		return
+	}
+	text := fmt.Sprintf("%s %d", act.ActionID, num)
+	if act.SelectedOption != nil {
+		text += " " + act.SelectedOption.Value
+	}
+	go func() {
+		defer func() { <-b.jobs }()
+		rec := b.startAudit(r, "button", text)
+		result := ""
+		defer func() { b.finishAudit(rec, result) }()
+		r := withAudit(r, rec)
+		if err := b.authorize(r, roleTriager, "change issues"); err != nil {
+			result = err.Error()
+			postResponse(p.ResponseURL, ephemeralMessage(result))
+			return
+		}
+		b.Lock()
+		agent := b.teamAgent(r)
+		b.Unlock()
+
+		var msg []byte
+		done, err := h(b, r, agent, num, act)
+		if err != nil {
+			log.Info("Unable to ", act.ActionID, " issue ", num, ": ", err)
+			result = fmt.Sprintf("Unable to update issue %d: %s", num, err)
+			msg = ephemeralMessage(result)
+		} else {
+			result = actor(r) + " " + done
+			msg, err = b.refreshIssue(r, agent, p.Message, num, result)
+			if err != nil {
+				log.Warn("Unable to refresh issue ", num, ": ", err)
+				msg = ephemeralMessage(result)
+			}
+		}
+		if err = postResponse(p.ResponseURL, msg); err != nil {
+			log.Warn("Unable to send action result: ", err)
+		}
+	}()
+}
+
+func closeAction(b *IssueBot, r *http.Request, a *github.Agent, num int, act *blockAction) (string, error) {
+	return "closed this issue", a.CloseIssue(num)
+}
+
+func reopenAction(b *IssueBot, r *http.Request, a *github.Agent, num int, act *blockAction) (string, error) {
+	return "reopened this issue", a.OpenIssue(num)
+}
+
+func assignMeAction(b *IssueBot, r *http.Request, a *github.Agent, num int, act *blockAction) (string, error) {
+	gname, _, err := b.resolveUser(r, "@me")
+	if err != nil {
+		return "", fmt.Errorf("register your github name with '/issue register' first")
+	}
+	return "took this issue", a.AssignIssue(num, gname)
+}
+
+func labelAction(b *IssueBot, r *http.Request, a *github.Agent, num int, act *blockAction) (string, error) {
+	if act.SelectedOption == nil {
+		return "", fmt.Errorf("no label selected")
+	}
+	label := act.SelectedOption.Value
+	return "added label `" + escape(label) + "`", a.LabelIssue(num, label)
+}
+
+// The parts of a message that are rewritten when an issue changes.
+// Blocks are kept raw so that blocks for other issues pass through
+// untouched.
+type rawMessage struct {
+	Text        string            `json:"text"`
+	Blocks      []json.RawMessage `json:"blocks,omitempty"`
+	Attachments []*rawAttachment  `json:"attachments,omitempty"`
+}
+
+type rawAttachment struct {
+	Color  string            `json:"color,omitempty"`
+	Blocks []json.RawMessage `json:"blocks"`
+}
+
+// Rebuild a message after one of its issues changed and return it as a
+// replacement for the original.  An issue card (from find) is redrawn
+// whole while an issue in a list only has its own entry redrawn.  The
+// activity line says who changed the issue.  Slack leaves the original
+// out of actions on ephemeral messages so the issue then gets a new card.
+func (b *IssueBot) refreshIssue(r *http.Request, a *github.Agent, orig json.RawMessage, num int, activity string) ([]byte, error) {
+	iss, err := a.GetIssue(num)
+	if err != nil {
+		return nil, err
+	}
+	labels := labelNames(a)
+	note := contextBlock(activity)
+	note.BlockID = issueBlockID(num, "activity")
+
+	b.Lock()
+	repo := b.teamRepo(r)
+	b.Unlock()
+	card := append(issueBlocks(repo, iss, b.displayNames(r, assigneeLogins(iss)), labels), note)
+	entry := append(issueListBlocks(iss, labels), note)
+
+	if len(orig) == 0 || string(orig) == "null" {
+		resp := newResponse(activity).addColored(stateColors[iss.State], card...)
+		resp.ResponseType = ephemeral
+		return json.Marshal(&struct {
+			ReplaceOriginal bool `json:"replace_original"`
+			*response
+		}{true, resp})
+	}
+	var m rawMessage
+	if err = json.Unmarshal(orig, &m); err != nil {
+		return nil, err
+	}
+
+	m.Blocks, _ = replaceIssueBlocks(m.Blocks, num, card, entry)
+	for _, att := range m.Attachments {
+		var found bool
+		if att.Blocks, found = replaceIssueBlocks(att.Blocks, num, card, entry); found {
+			att.Color = stateColors[iss.State]
+		}
+	}
+
+	return json.Marshal(&struct {
+		ReplaceOriginal bool `json:"replace_original"`
+		*rawMessage
+	}{true, &m})
+}
+
+// Replace the blocks for an issue with either the card or the list entry
+// depending on which the blocks were.  Returns true if the issue was found.
+func replaceIssueBlocks(blocks []json.RawMessage, num int, card []*block, entry []*block) ([]json.RawMessage, bool) {
+	var out []json.RawMessage
+	at := -1
+	isCard := false
+	for _, raw := range blocks {
+		var id struct {
+			BlockID string `json:"block_id"`
+		}
+		json.Unmarshal(raw, &id)
+		if n, ok := blockIssue(id.BlockID); ok && n == num {
+			if at < 0 {
+				at = len(out)
+			}
+			isCard = isCard || id.BlockID == issueBlockID(num, "header")
+			continue
+		}
+		out = append(out, raw)
+	}
+	if at < 0 {
+		return blocks, false
+	}
+	repl := entry
+	if isCard {
+		repl = card
+	}
+	var rawRepl []json.RawMessage
+	for _, blk := range repl {
+		data, _ := json.Marshal(blk)
+		rawRepl = append(rawRepl, data)
+	}
+	out = append(out[:at], append(rawRepl, out[at:]...)...)
+	return out, true
+}
+
+// Return the names of a repository's labels.  Returns nil if they can't
+// be read so that callers can go on without a label menu.
+func labelNames(a *github.Agent) []string {
+	labels, err := a.FetchLabels()
+	if err != nil {
+		log.WithField("method", "labelNames").Info("Unable to read labels: ", err)
+		return nil
+	}
+	var names []string
+	for _, l := range labels {
+		names = append(names, l.Name)
+	}
+	return names
+}
+
+// Build a plain ephemeral message for a response_url.
+func ephemeralMessage(text string) []byte {
+	data, _ := json.Marshal(&response{ResponseType: ephemeral, Text: text})
+	return data
+}
@@ -0,0 +1,366 @@
+package slack
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Store bucket that aliases defined with '/issue admin alias' are kept in
+const aliasesBucket = "aliases"
+
+// Most commands that one command line may run
+const maxSteps = 10
+
+// Matches the parameters of an alias:  $1 to $9, $* and $me.  "$$" is a
+// literal '$'.
+var paramRe = regexp.MustCompile(`\$([1-9*$]|me\b)`)
+
+// How an alias is kept in the store's aliases bucket under the key
+// "TEAM/NAME"
+type aliasRecord struct {
+	Team      string `json:"team"`
+	Name      string `json:"name"`
+	Expansion string `json:"expansion"`
+	By        string `json:"by"`
+}
+
+// One command of a command line or of an alias's expansion
+type step struct {
+	text string // the command as typed or expanded
+	cmd  *command
+	args *Args
+}
+
+// Return the handler that runs a step.
+func (st *step) handler() CommandFunc {
+	return st.cmd.guard("use /issue " + st.cmd.name)
+}
+
+// Parse the words of one command into a step.  text is the command line
+// the words came from.
+func parseStep(cmd *command, text string, words []*word) (*step, error) {
+	a, err := cmd.args.parse(cmd.name, text, words[1:])
+	if err != nil {
+		return nil, err
+	}
+	st := text[words[0].start:words[len(words)-1].end]
+	return &step{text: slackUnescaper.Replace(st), cmd: cmd, args: a}, nil
+}
+
+// Resolve the commands of a command line into steps, expanding aliases.
+// Every step is parsed before any of them run so that a mistake in one
+// doesn't leave the command line half done.
+func (b *IssueBot) commandSteps(r *http.Request, text string, cmds [][]*word) ([]*step, error) {
+	var steps []*step
+	for _, words := range cmds {
+		name := words[0].text
+		b.Lock()
+		cmd, isCmd := b.commands[name]
+		exp, isAlias := b.alias(r, name)
+		b.Unlock()
+
+		var err error
+		switch {
+		case isCmd:
+			var st *step
+			if st, err = parseStep(cmd, text, words); err == nil {
+				steps = append(steps, st)
+			}
+		case isAlias:
+			var more []*step
+			if more, err = b.expandAlias(r, name, exp, text, words[1:]); err == nil {
+				steps = append(steps, more...)
+			}
+		default:
+			err = errors.New(b.unknownCommand(r, name))
+		}
+		if err != nil && len(cmds) > 1 {
+			raw := text[words[0].start:words[len(words)-1].end]
+			err = fmt.Errorf("`/issue %s`:  %s", slackUnescaper.Replace(raw), err)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(steps) > maxSteps {
+		return nil, fmt.Errorf("at most %d commands may be run at once", maxSteps)
+	}
+	return steps, nil
+}
+
+// Expand an alias used with the words args into steps.  text is the
+// command line the words came from.
+func (b *IssueBot) expandAlias(r *http.Request, name string, exp string, text string, args []*word) ([]*step, error) {
+	line, err := b.substitute(r, name, exp, text, args)
+	if err != nil {
+		return nil, err
+	}
+	words, err := tokenize(line)
+	if err != nil {
+		return nil, fmt.Errorf("alias %s: %s", name, err)
+	}
+	var steps []*step
+	for _, cw := range splitCommands(words) {
+		b.Lock()
+		cmd, ok := b.commands[cw[0].text]
+		b.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("alias %s: unknown command %q", name, cw[0].text)
+		}
+		st, err := parseStep(cmd, line, cw)
+		if err != nil {
+			return nil, fmt.Errorf("/issue %s runs `/issue %s`:  %s", name,
+				slackUnescaper.Replace(line[cw[0].start:cw[len(cw)-1].end]), err)
+		}
+		steps = append(steps, st)
+	}
+	return steps, nil
+}
+
+// Substitute the arguments an alias was used with for its parameters.
+// Arguments are substituted as they were typed so that quotes and
+// mentions survive.
+func (b *IssueBot) substitute(r *http.Request, name string, exp string, text string, args []*word) (string, error) {
+	need, all := aliasParams(exp)
+	if len(args) < need {
+		return "", &usageError{fmt.Sprintf("missing $%d", len(args)+1), aliasUsage(name, exp)}
+	}
+	if len(args) > need && !all {
+		return "", &usageError{fmt.Sprintf("unexpected argument %q", args[need].display), aliasUsage(name, exp)}
+	}
+	raw := func(w *word) string {
+		return text[w.start:w.end]
+	}
+
+	var err error
+	line := paramRe.ReplaceAllStringFunc(exp, func(p string) string {
+		switch p {
+		case "$$":
+			return "$"
+		case "$*":
+			if len(args) == 0 {
+				return ""
+			}
+			return text[args[0].start:args[len(args)-1].end]
+		case "$me":
+			b.Lock()
+			gu, ok := b.s2g[requester(r)]
+			b.Unlock()
+			if !ok {
+				err = fmt.Errorf("/issue %s needs your github name:  register it with '/issue register' first", name)
+			}
+			return gu.login
+		}
+		return raw(args[p[1]-'1'])
+	})
+	return line, err
+}
+
+// Return the number of positional parameters an alias takes and whether
+// it takes all of its arguments with $*.
+func aliasParams(exp string) (int, bool) {
+	need, all := 0, false
+	for _, m := range paramRe.FindAllStringSubmatch(exp, -1) {
+		switch p := m[1]; {
+		case p == "*":
+			all = true
+		case p[0] >= '1' && p[0] <= '9' && int(p[0]-'0') > need:
+			need = int(p[0] - '0')
+		}
+	}
+	return need, all
+}
+
+// Return e.g. "/issue triage $1" for an alias.
+func aliasSynopsis(name string, exp string) string {
+	line := "/issue " + name
+	need, all := aliasParams(exp)
+	for i := 1; i <= need; i++ {
+		line += fmt.Sprintf(" $%d", i)
+	}
+	if all {
+		line += " [ARGS...]"
+	}
+	return line
+}
+
+// Return the usage message for an alias.
+func aliasUsage(name string, exp string) string {
+	return "usage: " + aliasSynopsis(name, exp) + "\nruns: " + exp
+}
+
+// Return the expansion of an alias in the workspace that sent a request.
+// Must be called with the lock held.
+func (b *IssueBot) alias(r *http.Request, name string) (string, bool) {
+	if exp, ok := b.aliases[teamKey{r.PostForm.Get("team_id"), name}]; ok {
+		return exp, true
+	}
+	exp, ok := b.config.Aliases[name]
+	return exp, ok
+}
+
+// Return the aliases of the workspace that sent a request by name.  Must
+// be called with the lock held.
+func (b *IssueBot) teamAliases(r *http.Request) map[string]string {
+	all := make(map[string]string)
+	for name, exp := range b.config.Aliases {
+		all[name] = exp
+	}
+	team := r.PostForm.Get("team_id")
+	for k, exp := range b.aliases {
+		if k.team == team {
+			all[k.key] = exp
+		}
+	}
+	return all
+}
+
+// Return the names of a map's aliases in order.
+func aliasNames(aliases map[string]string) []string {
+	var names []string
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Return an error if an alias can't be defined.  Must be called with the
+// lock held.
+func (b *IssueBot) checkAlias(name string, exp string) error {
+	if name == "" || strings.IndexFunc(name, unicode.IsSpace) >= 0 || strings.ContainsAny(name, `;$"'\<`) {
+		return fmt.Errorf("invalid alias name %q", name)
+	}
+	if _, ok := b.commands[name]; ok {
+		return fmt.Errorf("%q is already a command", name)
+	}
+	words, err := tokenize(exp)
+	if err != nil {
+		return fmt.Errorf("alias %s: %s", name, err)
+	}
+	cmds := splitCommands(words)
+	if len(cmds) == 0 {
+		return fmt.Errorf("alias %s runs nothing", name)
+	}
+	if len(cmds) > maxSteps {
+		return fmt.Errorf("alias %s runs more than %d commands", name, maxSteps)
+	}
+	for _, cw := range cmds {
+		if _, ok := b.commands[cw[0].text]; !ok {
+			return fmt.Errorf("alias %s: unknown command %q", name, cw[0].text)
+		}
+	}
+	return nil
+}
+
+// Load the aliases kept in the store.  Must be called with the lock held.
+func (b *IssueBot) loadAliases() error {
+	keys, err := b.store.Keys(aliasesBucket)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		var rec aliasRecord
+		if _, err = b.store.Get(aliasesBucket, k, &rec); err != nil {
+			return err
+		}
+		b.aliases[teamKey{rec.Team, rec.Name}] = rec.Expansion
+	}
+	log.WithField("method", "loadAliases").Infof("Loaded %d aliases", len(keys))
+	return nil
+}
+
+// Return a handler that runs steps in turn and replies with the result of
+// each.  The changes they make are undone together.
+func runSteps(steps []*step) CommandFunc {
+	return func(b *IssueBot, w http.ResponseWriter, r *http.Request, _ *Args) {
+		r = withUndoGroup(r)
+		var results []string
+		for _, st := range steps {
+			bw := newBufferedWriter()
+			st.handler()(b, bw, r, st.args)
+			results = append(results, fmt.Sprintf("`/issue %s`:  %s", st.text, replyText(bw.body.Bytes())))
+		}
+		w.Write([]byte(strings.Join(results, "\n")))
+	}
+}
+
+// Arguments of '/issue admin alias' and '/issue admin unalias'
+var (
+	aliasArgs = &schema{
+		name: "alias",
+		args: []*argSpec{{name: "NAME", optional: true}, {name: "EXPANSION", optional: true, rest: true}},
+		note: `EXPANSION is one or more commands separated by ";" (so quote it) in which ` +
+			"$1 to $9 stand for the alias's arguments, $* for all of them and $me for your github name",
+	}
+	unaliasArgs = &schema{name: "unalias", args: []*argSpec{{name: "NAME"}}}
+)
+
+// /issue admin alias [NAME [EXPANSION...]]
+//
+// Lists the workspace's aliases, shows one or defines one.
+func adminAlias(b *IssueBot, r *http.Request, a *Args) string {
+	b.Lock()
+	defer b.Unlock()
+	aliases := b.teamAliases(r)
+	name := a.Get("NAME")
+	switch {
+	case !a.Has("NAME"):
+		if len(aliases) == 0 {
+			return "No aliases are defined"
+		}
+		msg := "Aliases:"
+		for _, name := range aliasNames(aliases) {
+			msg += fmt.Sprintf("\n\t%s - `%s`", aliasSynopsis(name, aliases[name]), aliases[name])
+		}
+		return msg
+	case !a.Has("EXPANSION"):
+		exp, ok := aliases[name]
+		if !ok {
+			return fmt.Sprintf("There is no alias %q", name)
+		}
+		return aliasUsage(name, exp)
+	}
+
+	// a single word is the expansion quoted to protect its ';'s
+	exp := a.Text("EXPANSION")
+	if len(a.List("EXPANSION")) == 1 {
+		exp = a.Get("EXPANSION")
+	}
+	if err := b.checkAlias(name, exp); err != nil {
+		return err.Error()
+	}
+	u := requester(r)
+	rec := &aliasRecord{Team: u.team, Name: name, Expansion: exp, By: u.key}
+	if err := b.store.Put(aliasesBucket, u.team+"/"+name, rec); err != nil {
+		log.WithField("method", "adminAlias").Error("Unable to save alias ", name, ": ", err)
+		return fmt.Sprintf("Unable to save alias %q", name)
+	}
+	b.aliases[teamKey{u.team, name}] = exp
+	return fmt.Sprintf("`%s` now runs `%s`", aliasSynopsis(name, exp), exp)
+}
+
+// /issue admin unalias NAME
+func adminUnalias(b *IssueBot, r *http.Request, a *Args) string {
+	name := a.Get("NAME")
+	u := requester(r)
+	b.Lock()
+	defer b.Unlock()
+	if _, ok := b.aliases[teamKey{u.team, name}]; !ok {
+		if _, ok = b.config.Aliases[name]; ok {
+			return fmt.Sprintf("Alias %q is defined in the config file", name)
+		}
+		return fmt.Sprintf("There is no alias %q", name)
+	}
+	if err := b.store.Delete(aliasesBucket, u.team+"/"+name); err != nil {
+		log.WithField("method", "adminUnalias").Error("Unable to delete alias ", name, ": ", err)
+		return fmt.Sprintf("Unable to delete alias %q", name)
+	}
+	delete(b.aliases, teamKey{u.team, name})
+	return fmt.Sprintf("Removed alias %q", name)
+}
@@ -0,0 +1,375 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ctelfer-docker/slkiss/github"
+	"github.com/ctelfer-docker/slkiss/slackapi"
+)
+
+// Callback ID of the new issue form
+const newIssueCallback = "issue.new"
+
+// Longest issue title accepted from the form
+const maxTitleLen = 256
+
+// A modal view.  See:
+//   https://api.slack.com/reference/surfaces/views
+type view struct {
+	Type            string      `json:"type"`
+	CallbackID      string      `json:"callback_id"`
+	Title           *textObject `json:"title"`
+	Submit          *textObject `json:"submit,omitempty"`
+	Close           *textObject `json:"close,omitempty"`
+	PrivateMetadata string      `json:"private_metadata,omitempty"`
+	Blocks          []*block    `json:"blocks"`
+}
+
+// A view as submitted by a user along with the values of its inputs by
+// block ID and action ID
+type submittedView struct {
+	ID              string `json:"id"`
+	CallbackID      string `json:"callback_id"`
+	PrivateMetadata string `json:"private_metadata"`
+	State           struct {
+		Values map[string]map[string]*inputValue `json:"values"`
+	} `json:"state"`
+}
+
+// The value of one modal input
+type inputValue struct {
+	Type            string    `json:"type"`
+	Value           string    `json:"value"`
+	SelectedOption  *option   `json:"selected_option"`
+	SelectedOptions []*option `json:"selected_options"`
+}
+
+// Where to report on an issue created from the form.  This is kept in the
+// view's private_metadata between opening and submitting the form.
+type newIssueMeta struct {
+	Channel  string `json:"channel,omitempty"`
+	ThreadTS string `json:"thread_ts,omitempty"`
+}
+
+// /issue new [TITLE...]
+func newIssue(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
+	log := log.WithField("method", "newIssue")
+	msg := ""
+	defer func(){w.Write([]byte(msg))}()
+
+	trigger, err := getField("trigger_id", r)
+	if err != nil {
+		reqErr(log, w, err)
+		return
+	}
+	meta := &newIssueMeta{Channel: r.PostForm.Get("channel_id")}
+	if err = b.openNewIssue(r, trigger, a.Get("TITLE"), "", meta, nil); err != nil {
+		log.Warn("Unable to open new issue form: ", err)
+		msg = "Unable to open the new issue form"
+		if err == errNoBotToken {
+			msg += ":  no slack bot token is set"
+		}
+	}
+}
+
+var errNoBotToken = fmt.Errorf("no slack bot token")
+
+// The contents of the new issue form
+type newIssueForm struct {
+	repos  []string // the repositories to choose from
+	repo   string   // the chosen repository
+	title  string
+	body   string
+	labels []string
+	users  []*option
+	meta   string // private_metadata
+}
+
+// Build the new issue form.  The label and assignee menus are left out
+// until they have choices.
+func (f *newIssueForm) view() *view {
+	v := &view{
+		Type:            "modal",
+		CallbackID:      newIssueCallback,
+		Title:           plainText("New issue"),
+		Submit:          plainText("Create"),
+		Close:           plainText("Cancel"),
+		PrivateMetadata: f.meta,
+	}
+
+	// choosing a repository sends an action to load its labels
+	repo := staticSelect("value", "Repository", f.repos)
+	for _, o := range repo.Options {
+		if o.Value == f.repo {
+			repo.InitialOption = o
+		}
+	}
+	rb := inputBlock("repo", "Repository", repo, false)
+	rb.DispatchAction = true
+	v.Blocks = append(v.Blocks, rb)
+
+	te := &element{Type: "plain_text_input", ActionID: "value", InitialValue: f.title, MaxLength: maxTitleLen}
+	v.Blocks = append(v.Blocks, inputBlock("title", "Title", te, false))
+
+	be := &element{Type: "plain_text_input", ActionID: "value", InitialValue: f.body, Multiline: true}
+	v.Blocks = append(v.Blocks, inputBlock("body", "Description", be, true))
+
+	if len(f.labels) > 0 {
+		le := staticSelect("value", "Choose labels", f.labels)
+		le.Type = "multi_static_select"
+		v.Blocks = append(v.Blocks, inputBlock("labels", "Labels", le, true))
+	}
+
+	if len(f.users) > 0 {
+		ae := &element{Type: "static_select", ActionID: "value", Placeholder: plainText("Choose someone"), Options: f.users}
+		v.Blocks = append(v.Blocks, inputBlock("assignee", "Assignee", ae, true))
+	}
+	return v
+}
+
+// Open the new issue form with an optional title and body filled in.
+// Trigger IDs expire after 3 seconds so the form is opened before asking
+// github for labels.  The labels and assignees are added in the
+// background after calling prepare, if set, to finish filling in the
+// form.  An error from prepare replaces the form.
+func (b *IssueBot) openNewIssue(r *http.Request, trigger string, title string, body string, meta *newIssueMeta, prepare func(*newIssueForm) error) error {
+	b.Lock()
+	api := b.teamAPI(r.PostForm.Get("team_id"))
+	repos := b.teamRepos(r)
+	b.Unlock()
+	if api.Token() == "" {
+		return errNoBotToken
+	}
+
+	md, _ := json.Marshal(meta)
+	f := &newIssueForm{repos: repos, repo: repos[0], title: title, body: body, meta: string(md)}
+	opened, err := api.OpenView(trigger, f.view())
+	if err != nil {
+		return err
+	}
+	go b.fillNewIssue(r, api, opened, f, prepare)
+	return nil
+}
+
+// Finish an open new issue form and add the labels of the chosen
+// repository and the registered users to it.  Passing the hash the view
+// was opened with keeps this from undoing a change of repository made in
+// the meantime.
+func (b *IssueBot) fillNewIssue(r *http.Request, api *slackapi.Client, opened *slackapi.OpenedView, f *newIssueForm, prepare func(*newIssueForm) error) {
+	log := log.WithField("method", "fillNewIssue")
+	var v *view
+	if prepare != nil {
+		if err := prepare(f); err != nil {
+			v = noticeView("New issue", err.Error())
+		}
+	}
+	if v == nil {
+		b.loadChoices(r, f)
+		if prepare == nil && len(f.labels) == 0 && len(f.users) == 0 {
+			return
+		}
+		v = f.view()
+	}
+	if _, err := api.UpdateView(opened.ID, opened.Hash, v); err != nil {
+		log.Info("Unable to update new issue form: ", err)
+	}
+}
+
+// Build a modal that only shows a message.
+func noticeView(title string, text string) *view {
+	return &view{
+		Type:   "modal",
+		Title:  plainText(title),
+		Close:  plainText("Close"),
+		Blocks: []*block{sectionBlock(escape(text))},
+	}
+}
+
+// Look up the choices for the label and assignee menus of a new issue
+// form.
+func (b *IssueBot) loadChoices(r *http.Request, f *newIssueForm) {
+	b.Lock()
+	agent := b.repoAgent(f.repo)
+	b.Unlock()
+	f.labels = labelNames(agent)
+	f.users = b.userOptions(r)
+}
+
+// Handle a change of repository in the new issue form by offering the
+// new repository's labels.  The title and description typed so far are
+// kept.
+func newIssueAction(b *IssueBot, r *http.Request, p *interaction, act *blockAction) {
+	if act.BlockID != "repo" || act.SelectedOption == nil {
+		return
+	}
+	b.Lock()
+	api := b.teamAPI(p.Team.ID)
+	repos := b.teamRepos(r)
+	b.Unlock()
+	f := &newIssueForm{repos: repos, meta: p.View.PrivateMetadata}
+	for _, repo := range repos {
+		if repo == act.SelectedOption.Value {
+			f.repo = repo
+		}
+	}
+	if f.repo == "" {
+		return
+	}
+	values := p.View.State.Values
+	if v, ok := values["title"]["value"]; ok {
+		f.title = v.Value
+	}
+	if v, ok := values["body"]["value"]; ok {
+		f.body = v.Value
+	}
+
+	go func() {
+		b.loadChoices(r, f)
+		if _, err := api.UpdateView(p.View.ID, "", f.view()); err != nil {
+			log.WithField("method", "newIssueAction").Info("Unable to update new issue form: ", err)
+		}
+	}()
+}
+
+// Return the repositories that users in the workspace that sent a request
+// may file issues in, starting with the workspace's own repository.
+// Must be called with the lock held.
+func (b *IssueBot) teamRepos(r *http.Request) []string {
+	repos := []string{b.teamRepo(r)}
+	for _, repo := range b.config.Repos {
+		if repo != repos[0] {
+			repos = append(repos, repo)
+		}
+	}
+	return repos
+}
+
+// Handle a submitted modal.
+func viewSubmission(b *IssueBot, w http.ResponseWriter, r *http.Request, p *interaction) {
+	if p.View == nil || p.View.CallbackID != newIssueCallback {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	submitNewIssue(b, w, r, p)
+}
+
+// Create an issue from the new issue form.  The input is checked first
+// and any problems are shown next to the inputs in the form.  Otherwise
+// the form is closed and the issue created in the background since
+// github may take longer than slack waits for a reply.
+func submitNewIssue(b *IssueBot, w http.ResponseWriter, r *http.Request, p *interaction) {
+	log := log.WithField("method", "submitNewIssue")
+	values := p.View.State.Values
+	get := func(block string) *inputValue {
+		if v, ok := values[block]["value"]; ok {
+			return v
+		}
+		return &inputValue{}
+	}
+
+	var meta newIssueMeta
+	json.Unmarshal([]byte(p.View.PrivateMetadata), &meta)
+	r.PostForm.Set("channel_id", meta.Channel)
+
+	// message shortcuts open the form before checking the user's role
+	errs := make(map[string]string)
+	if err := b.authorize(r, roleReporter, "file issues"); err != nil {
+		errs["title"] = err.Error()
+	}
+
+	ni := &github.NewIssue{
+		Title: strings.TrimSpace(get("title").Value),
+		Body:  get("body").Value,
+	}
+	if ni.Title == "" {
+		errs["title"] = "An issue needs a title"
+	} else if len([]rune(ni.Title)) > maxTitleLen {
+		errs["title"] = fmt.Sprintf("Titles may be at most %d characters", maxTitleLen)
+	}
+	for _, o := range get("labels").SelectedOptions {
+		ni.Labels = append(ni.Labels, o.Value)
+	}
+
+	if o := get("assignee").SelectedOption; o != nil {
+		gname, _, err := b.resolveUser(r, o.Value)
+		if err != nil {
+			errs["assignee"] = fmt.Sprintf("%s is no longer registered", o.Text.Text)
+		}
+		ni.Assignees = []string{gname}
+	}
+
+	b.Lock()
+	repo := ""
+	if o := get("repo").SelectedOption; o != nil {
+		for _, rp := range b.teamRepos(r) {
+			if rp == o.Value {
+				repo = rp
+			}
+		}
+	}
+	if repo == "" {
+		errs["repo"] = "Choose one of the listed repositories"
+	}
+	var agent *github.Agent
+	if repo != "" {
+		agent = b.repoAgent(repo)
+	}
+	api := b.teamAPI(p.Team.ID)
+	b.Unlock()
+
+	if len(errs) > 0 {
+		resp := map[string]interface{}{"response_action": "errors", "errors": errs}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+
+	select {
+	case b.jobs <- struct{}{}:
+	default:
+		b.notify(r, api, &meta, busyMessage, false)
+		return
+	}
+	go func() {
+		defer func() { <-b.jobs }()
+		rec := b.startAudit(r, "form", "new "+ni.Title)
+		rec.entry.Repo = repo
+		iss, err := agent.WithObserver(rec.observe).CreateIssue(ni)
+		if err != nil {
+			log.Info("Unable to create issue in ", repo, ": ", err)
+			text := fmt.Sprintf("Unable to create issue %q: %s", ni.Title, err)
+			b.finishAudit(rec, text)
+			b.notify(r, api, &meta, text, false)
+			return
+		}
+		text := fmt.Sprintf("%s filed %s '%s'", actor(r),
+			link(iss.HTMLURL, fmt.Sprintf("%s#%d", repo, iss.Number)), escape(iss.Title))
+		b.finishAudit(rec, text)
+		// issues filed about a message are always announced in its thread
+		public := meta.ThreadTS != "" || b.responseType(r, "new") == inChannel
+		b.notify(r, api, &meta, text, public)
+	}()
+}
+
+// Tell the user who submitted a form how it went.  Public messages go to
+// the channel the form was opened from and others are only shown to the
+// user.  Without a channel the message is sent to the user directly.
+func (b *IssueBot) notify(r *http.Request, api *slackapi.Client, meta *newIssueMeta, text string, public bool) {
+	var err error
+	user := r.PostForm.Get("user_id")
+	switch {
+	case meta.Channel == "":
+		_, err = api.PostMessage(newResponse(text).message(user, ""))
+	case public:
+		_, err = api.PostMessage(newResponse(text).message(meta.Channel, meta.ThreadTS))
+	default:
+		_, err = api.PostEphemeral(user, newResponse(text).message(meta.Channel, meta.ThreadTS))
+	}
+	if err != nil {
+		log.WithField("method", "notify").Warn("Unable to send message: ", err)
+	}
+}
@@ -0,0 +1,219 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Slack page that asks a user to approve installing the app
+var AuthorizeURL = "https://slack.com/oauth/v2/authorize"
+
+// Bot token scopes requested when the app is installed
+const oauthScopes = "commands,chat:write,users:read,links:read,links:write,channels:history,usergroups:read"
+
+// Time allowed between starting an install and slack calling back
+const oauthStateWindow = 10 * time.Minute
+
+// A slack workspace that the bot has been installed in
+type workspace struct {
+	TeamID    string    `json:"team_id"`
+	TeamName  string    `json:"team_name"`
+	BotToken  string    `json:"bot_token"`
+	BotUserID string    `json:"bot_user_id"`
+	Scope     string    `json:"scope"`
+	Installed time.Time `json:"installed"`
+}
+
+// OAuth app credentials and installs in progress
+type oauthConfig struct {
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	path         string
+	states       map[string]time.Time
+}
+
+// The parts of an oauth.v2.access response that the bot uses
+type oauthAccess struct {
+	AccessToken string `json:"access_token"`
+	Scope       string `json:"scope"`
+	BotUserID   string `json:"bot_user_id"`
+	Team        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"team"`
+}
+
+// Let the bot be installed in slack workspaces using the OAuth v2 flow.
+// This adds the handlers /slack/install, which sends the user to slack
+// to approve the install, and /slack/oauth/callback which slack
+// redirects back to.  The redirect URL may be empty if only one is
+// configured for the app.  The bot tokens for each workspace are saved
+// in the file at path.  Once this is enabled slash commands are only
+// accepted from workspaces the bot has been installed in.
+func (b *IssueBot) EnableOAuth(clientID, clientSecret, redirectURL, path string) error {
+	if clientID == "" || clientSecret == "" || path == "" {
+		return fmt.Errorf("OAuth needs a client ID, a client secret and a workspace file")
+	}
+	teams, err := loadWorkspaces(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.oauth = &oauthConfig{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		path:         path,
+		states:       make(map[string]time.Time),
+	}
+	for _, ws := range teams {
+		b.teams[ws.TeamID] = ws
+	}
+	b.mux.HandleFunc("/slack/install", b.oauthInstall)
+	b.mux.HandleFunc("/slack/oauth/callback", b.oauthCallback)
+	return nil
+}
+
+// Send the user to slack to approve installing the app.
+func (b *IssueBot) oauthInstall(w http.ResponseWriter, r *http.Request) {
+	state := newToken() + newToken()
+
+	b.Lock()
+	o := b.oauth
+	for s, t := range o.states {
+		if time.Since(t) > oauthStateWindow {
+			delete(o.states, s)
+		}
+	}
+	o.states[state] = time.Now()
+	b.Unlock()
+
+	q := url.Values{}
+	q.Set("client_id", o.clientID)
+	q.Set("scope", oauthScopes)
+	q.Set("state", state)
+	if o.redirectURL != "" {
+		q.Set("redirect_uri", o.redirectURL)
+	}
+	http.Redirect(w, r, AuthorizeURL+"?"+q.Encode(), http.StatusFound)
+}
+
+// Finish an install by exchanging the code slack sent for a bot token.
+func (b *IssueBot) oauthCallback(w http.ResponseWriter, r *http.Request) {
+	log := log.WithField("method", "oauthCallback")
+	q := r.URL.Query()
+
+	b.Lock()
+	o := b.oauth
+	t, ok := o.states[q.Get("state")]
+	delete(o.states, q.Get("state"))
+	b.Unlock()
+	if !ok || time.Since(t) > oauthStateWindow {
+		http.Error(w, "Unknown or expired install request:  please start again", http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		http.Error(w, "The install was not approved: "+e, http.StatusForbidden)
+		return
+	}
+
+	params := url.Values{}
+	params.Set("client_id", o.clientID)
+	params.Set("client_secret", o.clientSecret)
+	params.Set("code", q.Get("code"))
+	if o.redirectURL != "" {
+		params.Set("redirect_uri", o.redirectURL)
+	}
+	var acc oauthAccess
+	if err := b.slackapi.Call("oauth.v2.access", "", params, &acc); err != nil {
+		log.Warn("Unable to complete install: ", err)
+		http.Error(w, "Unable to complete the install with slack", http.StatusBadGateway)
+		return
+	}
+
+	ws := &workspace{
+		TeamID:    acc.Team.ID,
+		TeamName:  acc.Team.Name,
+		BotToken:  acc.AccessToken,
+		BotUserID: acc.BotUserID,
+		Scope:     acc.Scope,
+		Installed: time.Now(),
+	}
+	b.Lock()
+	b.teams[ws.TeamID] = ws
+	err := b.saveWorkspaces()
+	b.Unlock()
+	if err != nil {
+		log.Error("Unable to save workspace tokens: ", err)
+	}
+
+	log.Infof("Installed in workspace %s (%s)", ws.TeamName, ws.TeamID)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<p>issuebot is now installed in %s.</p>\n", html.EscapeString(ws.TeamName))
+}
+
+// Return the workspace with the given team ID if the bot is installed in
+// it.  Must be called with the lock held.
+func (b *IssueBot) workspace(team string) (*workspace, bool) {
+	ws, ok := b.teams[team]
+	return ws, ok
+}
+
+// Return the bot token to use for slack API calls for a workspace.
+// Must be called with the lock held.
+func (b *IssueBot) teamToken(team string) string {
+	if ws, ok := b.teams[team]; ok {
+		return ws.BotToken
+	}
+	return b.botToken
+}
+
+func loadWorkspaces(path string) ([]*workspace, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var teams []*workspace
+	if err = json.Unmarshal(data, &teams); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return teams, nil
+}
+
+// Save the workspace tokens.  The file holds secrets so it is only
+// readable by the owner.  Must be called with the lock held.
+func (b *IssueBot) saveWorkspaces() error {
+	var teams []*workspace
+	for _, ws := range b.teams {
+		teams = append(teams, ws)
+	}
+	data, err := json.MarshalIndent(teams, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := b.oauth.path
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".workspaces")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
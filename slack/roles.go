@@ -0,0 +1,214 @@
+package slack
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/ctelfer-docker/slkiss/github"
+)
+
+// What a slack user may do with the bot.  Each role may do everything
+// that the roles below it may.
+type role int
+
+const (
+	roleNone       role = iota // no role needed
+	roleReporter               // look up and file issues
+	roleTriager                // close, reopen, assign and label issues
+	roleMaintainer             // change many issues at once
+	roleAdmin                  // run '/issue admin' commands
+)
+
+// Roles by the names used in the config file
+var roleNames = map[string]role{
+	"reporter":   roleReporter,
+	"triager":    roleTriager,
+	"maintainer": roleMaintainer,
+	"admin":      roleAdmin,
+}
+
+// Roles given for github repository permissions.  (See
+// Config.GithubRoles.)
+var githubRoles = map[string]role{
+	"admin":    roleAdmin,
+	"maintain": roleMaintainer,
+	"write":    roleMaintainer,
+	"triage":   roleTriager,
+	"read":     roleReporter,
+}
+
+// How long user group members and github permissions are cached
+const roleCacheTTL = 10 * time.Minute
+
+// Match slack user and user group IDs
+var (
+	userIDRe = regexp.MustCompile(`^[UW][A-Z0-9]+$`)
+	groupRe  = regexp.MustCompile(`^S[A-Z0-9]+$`)
+)
+
+// Members of a slack user group from usergroups.users.list
+type cachedGroup struct {
+	members map[string]bool
+	fetched time.Time
+}
+
+// The role given for a github user's permission on a repository
+type cachedRole struct {
+	role    role
+	fetched time.Time
+}
+
+func (ro role) String() string {
+	for name, r := range roleNames {
+		if r == ro {
+			return name
+		}
+	}
+	return "nobody"
+}
+
+// Return an error explaining that a command needs a role if the user who
+// sent a request doesn't have it.
+func (b *IssueBot) authorize(r *http.Request, need role, what string) error {
+	if need == roleNone {
+		return nil
+	}
+	have := b.userRole(r)
+	switch {
+	case have >= need:
+		return nil
+	case need == roleAdmin:
+		return fmt.Errorf("Only users with the admin role may %s.  Your role is %s.", what, have)
+	}
+	return fmt.Errorf("Only users with the %s role or higher may %s.  Your role is %s.", need, what, have)
+}
+
+// Return the role of the slack user who sent a request:  the highest of
+// the default role, the roles given to the user or their user groups in
+// the config and, if enabled, the role for their registered github
+// user's permission on the workspace's repository.
+func (b *IssueBot) userRole(r *http.Request) role {
+	u := requester(r)
+	b.Lock()
+	cfg := b.config
+	roles := cfg.Roles
+	if ws, ok := cfg.Workspaces[u.team]; ok {
+		roles = ws.Roles
+	}
+	gu, registered := b.s2g[u]
+	agent := b.teamAgent(r)
+	b.Unlock()
+
+	best := roleNames[cfg.DefaultRole]
+	for name, members := range roles {
+		ro := roleNames[name]
+		if ro <= best {
+			continue
+		}
+		for _, m := range members {
+			if b.isMember(u, m) {
+				best = ro
+				break
+			}
+		}
+	}
+	if cfg.GithubRoles && registered && best < roleAdmin {
+		if ro := b.githubRole(agent, gu.login); ro > best {
+			best = ro
+		}
+	}
+	return best
+}
+
+// Returns true if a role member from the config is the slack user u or a
+// user group that u belongs to.
+func (b *IssueBot) isMember(u teamKey, member string) bool {
+	if member == u.key {
+		return true
+	}
+	if !groupRe.MatchString(member) {
+		return false
+	}
+
+	k := teamKey{u.team, member}
+	b.Lock()
+	cg, ok := b.groups[k]
+	api := b.teamAPI(u.team)
+	b.Unlock()
+	if ok && time.Since(cg.fetched) < roleCacheTTL {
+		return cg.members[u.key]
+	}
+	if api.Token() == "" {
+		return false
+	}
+	ids, err := api.UserGroupMembers(member)
+	if err != nil {
+		log.WithField("method", "isMember").Info("Unable to list members of user group ", member, ": ", err)
+		return false
+	}
+	cg = &cachedGroup{members: make(map[string]bool), fetched: time.Now()}
+	for _, id := range ids {
+		cg.members[id] = true
+	}
+	b.Lock()
+	b.groups[k] = cg
+	b.Unlock()
+	return cg.members[u.key]
+}
+
+// Return the role for a github user's permission on an agent's
+// repository.
+func (b *IssueBot) githubRole(agent *github.Agent, login string) role {
+	k := teamKey{agent.Repo(), login}
+	b.Lock()
+	cr, ok := b.perms[k]
+	b.Unlock()
+	if ok && time.Since(cr.fetched) < roleCacheTTL {
+		return cr.role
+	}
+	perm, err := agent.GetPermission(login)
+	if err != nil {
+		log.WithField("method", "githubRole").Info("Unable to get permission of ", login, " on ", agent.Repo(), ": ", err)
+		return roleNone
+	}
+	cr = &cachedRole{role: githubRoles[perm], fetched: time.Now()}
+	b.Lock()
+	b.perms[k] = cr
+	b.Unlock()
+	return cr.role
+}
+
+// Check the roles in a config.
+func checkRoles(roles map[string][]string) error {
+	for name := range roles {
+		if _, ok := roleNames[name]; !ok {
+			return fmt.Errorf("unknown role %q", name)
+		}
+	}
+	return nil
+}
+
+// Check that the members of roles are slack user or user group IDs.
+// User names aren't accepted since anyone can rename themselves.
+func checkMembers(roles map[string][]string) error {
+	for name, members := range roles {
+		for _, m := range members {
+			if !userIDRe.MatchString(m) && !groupRe.MatchString(m) {
+				return fmt.Errorf("role %s: %q is not a slack user or user group ID", name, m)
+			}
+		}
+	}
+	return nil
+}
+
+// Return a copy of roles with admins added to the admin role.
+func withAdmins(roles map[string][]string, admins []string) map[string][]string {
+	out := make(map[string][]string)
+	for name, members := range roles {
+		out[name] = append([]string(nil), members...)
+	}
+	out["admin"] = append(out["admin"], admins...)
+	return out
+}
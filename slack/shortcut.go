@@ -0,0 +1,99 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ctelfer-docker/slkiss/slackapi"
+)
+
+// Callback ID of the "Create issue from message" message shortcut.  This
+// must match the callback ID given when creating the shortcut in the
+// slack app settings.
+const fromMessageCallback = "issue.from_message"
+
+// Longest title taken from the first line of a message
+const maxShortcutTitle = 80
+
+// The message a message shortcut was used on
+type shortcutMessage struct {
+	Text     string `json:"text"`
+	User     string `json:"user"`
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts"`
+}
+
+// Handle a message shortcut by opening the new issue form filled in from
+// the message.  Once the issue is created the bot replies in the
+// message's thread with a link to it.
+func messageAction(b *IssueBot, w http.ResponseWriter, r *http.Request, p *interaction) {
+	log := log.WithField("method", "messageAction")
+	w.WriteHeader(http.StatusOK)
+	var m *shortcutMessage
+	if p.CallbackID != fromMessageCallback || json.Unmarshal(p.Message, &m) != nil || m == nil {
+		log.Debug("Ignoring message shortcut ", p.CallbackID)
+		return
+	}
+
+	b.Lock()
+	api := b.teamAPI(p.Team.ID)
+	b.Unlock()
+	if api.Token() == "" {
+		log.Warn("Unable to open new issue form: ", errNoBotToken)
+		return
+	}
+
+	// The form is opened before anything that talks to slack or github
+	// since the trigger ID expires after 3 seconds.  The title, body and
+	// link to the message are filled in once the user is authorized.
+	meta := &newIssueMeta{Channel: p.Channel.ID, ThreadTS: m.ThreadTS}
+	if meta.ThreadTS == "" {
+		meta.ThreadTS = m.TS
+	}
+	prepare := func(f *newIssueForm) error {
+		if err := b.authorize(r, roleReporter, "file issues"); err != nil {
+			return err
+		}
+		f.title, f.body = issueFromMessage(api, p.Channel.ID, m)
+		return nil
+	}
+	if err := b.openNewIssue(r, p.TriggerID, messageTitle(m), "", meta, prepare); err != nil {
+		log.Warn("Unable to open new issue form: ", err)
+	}
+}
+
+// Return the first line of a message as an issue title.
+func messageTitle(m *shortcutMessage) string {
+	title := strings.SplitN(strings.TrimSpace(m.Text), "\n", 2)[0]
+	return truncate(strings.TrimSpace(title), maxShortcutTitle)
+}
+
+// Work out the title and body for an issue about a message.  The title is
+// the first line of the message.  The body quotes the message and says
+// who wrote it with a link back to it.
+func issueFromMessage(api *slackapi.Client, channel string, m *shortcutMessage) (string, string) {
+	log := log.WithField("method", "issueFromMessage")
+	text := strings.TrimSpace(m.Text)
+	title := messageTitle(m)
+
+	author := m.User
+	if u, err := api.UserInfo(m.User); err == nil {
+		author = u.DisplayName()
+	} else {
+		log.Info("Unable to look up user ", m.User, ": ", err)
+	}
+	var lines []string
+	for _, l := range strings.Split(text, "\n") {
+		lines = append(lines, "> "+l)
+	}
+	body := strings.Join(lines, "\n") + "\n\n"
+	if link, err := api.Permalink(channel, m.TS); err == nil {
+		body += fmt.Sprintf("Reported by %s in [Slack](%s)", author, link)
+	} else {
+		log.Info("Unable to get link to message: ", err)
+		body += fmt.Sprintf("Reported by %s in Slack", author)
+	}
+	return title, body
+}
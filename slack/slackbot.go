@@ -7,38 +7,105 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
-	"strconv"
 	"sync"
+	"time"
 
+	"github.com/ctelfer-docker/slkiss/audit"
 	"github.com/ctelfer-docker/slkiss/github"
+	"github.com/ctelfer-docker/slkiss/mirror"
+	"github.com/ctelfer-docker/slkiss/slackapi"
+	"github.com/ctelfer-docker/slkiss/store"
 	"github.com/sirupsen/logrus"
 )
 
 var log = logrus.WithFields(logrus.Fields{"component": "slackbot"})
 
-type botHandlerFunc func(*IssueBot, http.ResponseWriter, *http.Request, []string)
-
-var handlers = map[string]botHandlerFunc{
-	"help":       help,
-	"find":       findIssue,
-	"close":      closeIssue,
-	"reopen":     reopenIssue,
-	"assign":     assignIssue,
-	"unassign":   unassignIssue,
-	"register":   registerUser,
-	"get-alias":  getAlias,
-	"unregister": unregisterUser,
+// The commands every bot has in the order '/issue help' lists them
+var builtins = []*command{
+	{name: "help", run: help, role: roleNone, args: helpArgs,
+		summary: "list the commands or show how to use one"},
+	{name: "find", run: findIssue, role: roleReporter, args: issueArgs, async: true,
+		summary: "show an issue"},
+	{name: "new", run: newIssue, role: roleReporter, args: newArgs,
+		summary: "file an issue"},
+	{name: "grep", run: grepIssues, role: roleReporter, args: grepArgs,
+		summary: "search for issues"},
+	{name: "close", run: closeIssue, role: roleTriager, args: issueArgs, async: true,
+		summary: "close an issue"},
+	{name: "reopen", run: reopenIssue, role: roleTriager, args: issueArgs, async: true,
+		summary: "reopen a closed issue"},
+	{name: "assign", run: assignIssue, role: roleTriager, args: assignArgs, async: true,
+		summary: "assign an issue to someone"},
+	{name: "unassign", run: unassignIssue, role: roleTriager, args: issueArgs, async: true,
+		summary: "remove an issue's assignees"},
+	{name: "label", run: labelIssue, role: roleTriager, args: labelArgs, async: true,
+		summary: "add or remove an issue's labels"},
+	{name: "undo", run: undoCommand, role: roleTriager, args: noArgs, async: true,
+		summary: "take back your last change to issues"},
+	{name: "bulk", run: bulkCommand, role: roleMaintainer, args: bulkArgs, async: true,
+		summary: "change every issue that a query matches"},
+	{name: "admin", run: adminCommand, role: roleAdmin, args: adminArgs, async: true,
+		summary: "run the stale issue sweeper, read the audit log and manage aliases"},
+	{name: "register", run: registerUser, role: roleNone, args: registerArgs, async: true,
+		summary: "link your slack user to a github user",
+		details: "Run it with your github name to get a code to put in your github profile " +
+			"and then again with no arguments once the code is there."},
+	{name: "get-alias", run: getAlias, role: roleNone, args: noArgs,
+		summary: "show the github user you are registered as"},
+	{name: "unregister", run: unregisterUser, role: roleNone, args: noArgs,
+		summary: "forget your github user"},
 }
 
+// Arguments of commands
+var (
+	helpArgs     = &schema{args: []*argSpec{{name: "CMD", optional: true, rest: true}}}
+	newArgs      = &schema{args: []*argSpec{{name: "TITLE", kind: argText, optional: true, rest: true}}}
+	assignArgs   = &schema{args: []*argSpec{{name: "NUM", kind: argIssue}, {name: "USER", kind: argUser}}}
+	registerArgs = &schema{args: []*argSpec{{name: "GITHUBUSER", optional: true}}}
+	labelArgs    = &schema{
+		args: []*argSpec{{name: "NUM", kind: argIssue}, {name: "LABEL", rest: true}},
+		note: `Each LABEL is added, or removed if it starts with "-".  "+LABEL" also adds it.`,
+	}
+	grepArgs     = &schema{
+		args: []*argSpec{{name: "TERMS", kind: argQuery, rest: true}},
+		note: `TERMS may include "PHRASES" and label:L, assignee:U and state:S filters`,
+	}
+)
+
 // Bot implements a slackbot that manages 
 type IssueBot struct {
 	sync.Mutex
 	addr     string
+	repo     string
+	api      string
+	config   *Config
+	verifier verifier
+	oauth    *oauthConfig
+	botToken string
+	appToken string
+	slackapi *slackapi.Client
+	teams    map[string]*workspace
 	mux      *http.ServeMux
 	agent    *github.Agent
-	dispatch map[string]botHandlerFunc
-	g2s      map[string]string
-	s2g      map[string]string
+	mirror   *mirror.Mirror
+	commands map[string]*command
+	cmdList  []*command
+	g2s      map[teamKey]string
+	s2g      map[teamKey]ghUser
+	pending  map[teamKey]*pendingUser
+	store    store.Store
+	audit    *audit.Log
+	auditTok string
+	groups   map[teamKey]*cachedGroup
+	perms    map[teamKey]*cachedRole
+	byName   map[string]string
+	users    map[teamKey]*cachedUser
+	bulk     map[string]*bulkOp
+	undo     map[teamKey]*undoAction
+	aliases  map[teamKey]string
+	jobs     chan struct{}
+	events   map[string]time.Time
+	linked   map[string][]time.Time
 }
 
 
@@ -52,11 +119,38 @@ type botHandlerCtx struct {
 func NewIssueBot(addr string, repo string) *IssueBot {
 	b := &IssueBot{}
 	b.addr = addr
+	b.repo = repo
+	b.api = github.APIRoot
+	b.slackapi = slackapi.New("")
+	b.config = &Config{}
+	b.config.validate(repo)
 	b.mux = http.NewServeMux()
 	b.agent = github.NewRepoAgent(repo)
 	b.mux.Handle("/issue", &botHandlerCtx{b})
-	b.g2s = make(map[string]string)
-	b.s2g = make(map[string]string)
+	b.mux.HandleFunc("/slack/interactive", b.serveInteractive)
+	b.mux.HandleFunc("/slack/events", b.serveEvents)
+	b.mux.HandleFunc("/audit", b.serveAudit)
+	b.g2s = make(map[teamKey]string)
+	b.s2g = make(map[teamKey]ghUser)
+	b.pending = make(map[teamKey]*pendingUser)
+	b.store, _ = store.Open("")
+	b.audit, _ = audit.Open("")
+	b.groups = make(map[teamKey]*cachedGroup)
+	b.perms = make(map[teamKey]*cachedRole)
+	b.byName = make(map[string]string)
+	b.users = make(map[teamKey]*cachedUser)
+	b.bulk = make(map[string]*bulkOp)
+	b.undo = make(map[teamKey]*undoAction)
+	b.aliases = make(map[teamKey]string)
+	b.teams = make(map[string]*workspace)
+	b.jobs = make(chan struct{}, maxJobs)
+	b.events = make(map[string]time.Time)
+	b.linked = make(map[string][]time.Time)
+	b.verifier.now = time.Now
+	b.commands = make(map[string]*command)
+	for _, cmd := range builtins {
+		b.addCommand(cmd)
+	}
 	return b
 }
 
@@ -65,31 +159,146 @@ func (b *IssueBot) SetGithubAuth(token string) {
 	b.agent.SetToken(token)
 }
 
+// Set the slack bot token used to call the slack Web API (e.g. to open
+// forms).  Workspaces that installed the bot through OAuth use their own
+// tokens instead.
+func (b *IssueBot) SetBotToken(token string) {
+	b.botToken = token
+}
+
+// Send Github requests to the API rooted at api rather than the public
+// Github API.  This must be called before EnableMirror().
+func (b *IssueBot) SetGithubURL(api string) {
+	a := github.NewRepoAgentURL(api, b.repo)
+	a.SetToken(b.agent.Token())
+	b.agent = a
+	b.api = api
+}
+
+// Keep registered users and other bot state in st so that they survive
+// restarts.  State already in st is loaded.  This must be called before
+// Run() and before adding users.
+func (b *IssueBot) SetStore(st store.Store) error {
+	b.Lock()
+	defer b.Unlock()
+	b.store = st
+	if err := b.loadUsers(); err != nil {
+		return err
+	}
+	return b.loadAliases()
+}
+
+// Apply settings from a config file.  (See LoadConfig())  Commands that
+// the config's aliases run must be registered first.
+func (b *IssueBot) SetConfig(cfg *Config) error {
+	if err := cfg.validate(b.repo); err != nil {
+		return err
+	}
+	b.Lock()
+	defer b.Unlock()
+	for name, exp := range cfg.Aliases {
+		if err := b.checkAlias(name, exp); err != nil {
+			return err
+		}
+	}
+	b.config = cfg
+	return nil
+}
+
+// Return an agent for a repository.  Must be called with the lock held.
+func (b *IssueBot) repoAgent(repo string) *github.Agent {
+	if repo == b.repo {
+		return b.agent
+	}
+	a := github.NewRepoAgentURL(b.api, repo)
+	a.SetToken(b.agent.Token())
+	return a
+}
+
+// Return the repository managed by slash commands from the workspace
+// that sent a request.  Must be called with the lock held.
+func (b *IssueBot) teamRepo(r *http.Request) string {
+	if ws, ok := b.config.Workspaces[r.PostForm.Get("team_id")]; ok {
+		return ws.Repo
+	}
+	return b.repo
+}
+
+// Return an agent for the repository managed by slash commands from the
+// workspace that sent a request.  Changes it makes are added to the
+// request's audit record and can be undone with '/issue undo'.  Must be
+// called with the lock held.
+func (b *IssueBot) teamAgent(r *http.Request) *github.Agent {
+	repo := b.teamRepo(r)
+	a := auditAgent(r, b.repoAgent(repo))
+	if r.PostForm.Get("user_id") == "" {
+		return a
+	}
+	return a.WithSnapshots(b.undoRecorder(r, repo))
+}
+
+// Returns true if the bot accepts slash commands from a workspace.
+// Without OAuth every workspace is accepted.
+func (b *IssueBot) installed(team string) bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.oauth == nil {
+		return true
+	}
+	_, ok := b.workspace(team)
+	return ok
+}
+
+// Keep a local mirror of the repository's issues for '/issue grep'.
+// If path is not empty the mirror is persisted to that file.
+func (b *IssueBot) EnableMirror(path string) error {
+	m, err := mirror.New(b.agent, path)
+	if err != nil {
+		return err
+	}
+	b.mirror = m
+	return nil
+}
+
 // Add a mapping from a slack username (sname) to a github username (gname).
+// Anyone can take a slack user name so the mapping is only used to name
+// users and never says who sent a request.  It is moved to the user's ID
+// once they show that they own the github account with '/issue register'.
 func (b *IssueBot) AddUserMap(sname string, gname string) bool{
-	if _, ok := b.g2s[gname]; ok {
+	if _, ok := b.byName[sname]; ok {
 		return false
 	}
-	if _, ok := b.s2g[sname]; ok {
-		return false
+	for _, g := range b.byName {
+		if g == gname {
+			return false
+		}
 	}
-	b.g2s[gname] = sname
-	b.s2g[sname] = gname
+	b.byName[sname] = gname
 	return true
 }
 
-// Delete the name mappings for the user specified by the slack name sname.
+// Delete a mapping added with AddUserMap() that hasn't been moved yet.
 func (b *IssueBot) DelUserBySlack(sname string) {
-	gname, ok := b.s2g[sname]
-	if ok {
-		delete(b.g2s, gname)
-		delete(b.s2g, sname)
-	}
-
+	delete(b.byName, sname)
 }
 
+// How often to refresh the issue mirror from github
+const mirrorSyncInterval = 10 * time.Minute
+
 // Start the http server in the issuebot
 func (b *IssueBot) Run() {
+	if b.mirror != nil {
+		go b.mirror.Run(mirrorSyncInterval, nil)
+	}
+	if len(b.config.Sweep) > 0 {
+		go b.sweepLoop(nil)
+	}
+	if b.appToken != "" {
+		go b.socketLoop(nil)
+	}
+	if !b.verifier.enabled() {
+		log.Warn("No slack signing secret or verification token set:  accepting all requests")
+	}
 	log.Fatal(http.ListenAndServe(b.addr, b.mux))
 }
 
@@ -98,57 +307,79 @@ func (b *IssueBot) Run() {
 // the subcommand.
 func (c *botHandlerCtx)ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	b := c.b
+	if err := b.verifier.verifySignature(r); err != nil {
+		verifyErr(w, r, err)
+		return
+	}
 	if err := r.ParseForm(); err != nil {
 		reqErr(log, w, err)
 		return
 	}
+	if err := b.verifier.checkToken(r.PostForm.Get("token")); err != nil {
+		verifyErr(w, r, err)
+		return
+	}
+	if !b.installed(r.PostForm.Get("team_id")) {
+		w.Write([]byte("issuebot has not been installed in this workspace"))
+		return
+	}
 	text, err := getField("text", r)
 	if err != nil {
 		reqErr(log, w, err)
 		return
 	}
-	fields := strings.Fields(text)
-	if len(fields) == 0 {
-		help(b, w, r, nil)
+	// The audit record is started before the command is parsed so that
+	// unknown commands and usage errors are logged too.
+	rec := b.startAudit(r, "command", text)
+	reject := func(msg string) {
+		w.Write([]byte(msg))
+		b.finishAudit(rec, msg)
+	}
+	words, err := tokenize(text)
+	if err != nil {
+		reject(err.Error())
 		return
 	}
-
-	h, ok := handlers[fields[0]]
-	if !ok {
-		h = help
+	cmds := splitCommands(words)
+	if len(cmds) == 0 {
+		audited(rec, help)(b, w, r, &Args{})
+		return
 	}
-	h(b, w, r, fields[1:])
-}
 
-func help(b *IssueBot, w http.ResponseWriter, r *http.Request, f []string) {
-	w.Write([]byte(`usage: /issue CMD [params]
-Commands:
-	/issue find NUM
-	/issue close NUM
-	/issue reopen NUM
-	/issue assign NUM [@SLACKNAME|@me|GITHUBNAME]
-	/issue unassign NUM
-	/issue register GITHUBUSER
-	/issue get-alias
-	/issue unregister
-`))
+	steps, err := b.commandSteps(r, text, cmds)
+	if err != nil {
+		reject(err.Error())
+		return
+	}
+	h, a, async := steps[0].handler(), steps[0].args, steps[0].cmd.async
+	if len(steps) > 1 {
+		h, a = runSteps(steps), nil
+		for _, st := range steps {
+			async = async || st.cmd.async
+		}
+	}
+	h = audited(rec, h)
+	if async && r.PostForm.Get("response_url") != "" {
+		b.runAsync(w, r, h, a)
+		return
+	}
+	h(b, w, r, a)
 }
 
-func findIssue(b *IssueBot, w http.ResponseWriter, r *http.Request, f []string) {
-	var assignee string
+func findIssue(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
+	var resp *response
 
 	log := log.WithField("method", "findIssue")
-	msg := "usage: /issue find NUMBER"
-	defer func(){w.Write([]byte(msg))}()
+	msg := ""
+	defer func(){writeResponse(w, resp, msg)}()
 
-	inum, ok := parseSimpleNumCmd(w, r, f)
-	if !ok {
-		return
-	}
+	inum := a.Num("NUM")
 
 	b.Lock()
-	issue, err := b.agent.GetIssue(inum)
+	repo := b.teamRepo(r)
+	agent := b.teamAgent(r)
 	b.Unlock()
+	issue, err := agent.GetIssue(inum)
 
 	if err != nil {
 		msg = fmt.Sprintf("Unable to find issue %d", inum)
@@ -156,180 +387,332 @@ func findIssue(b *IssueBot, w http.ResponseWriter, r *http.Request, f []string)
 		return
 	}
 
-	if issue.Assignee == nil {
-		assignee = ""
-	} else {
-		assignee = issue.Assignee.Login
-		if s, ok := b.g2s[assignee]; ok {
-			assignee = "@" + s
-		}
-		assignee = "\tAssigned to: " + assignee
+	assignees := b.displayNames(r, assigneeLogins(issue))
+	assignee := ""
+	if len(assignees) > 0 {
+		assignee = "\tAssigned to: " + strings.Join(assignees, ", ")
 	}
 
 	msg = fmt.Sprintf("Issue %d: %q\n\tURL: %s\n\tState: %s\n%s", inum, issue.Title, issue.HTMLURL, issue.State, assignee)
+	labels := labelNames(agent)
+	resp = newResponse(msg).addColored(stateColors[issue.State], issueBlocks(repo, issue, assignees, labels)...)
+	resp.ResponseType = b.responseType(r, "find")
 }
 
-func closeIssue(b *IssueBot, w http.ResponseWriter, r *http.Request, f []string) {
-	log := log.WithField("method", "closeIssue")
-	msg := "usage: /issue close NUMBER"
-	defer func(){w.Write([]byte(msg))}()
+// Return the names to show for github users:  "@SLACKNAME" for users
+// registered in the workspace that sent a request and the github name for
+// everyone else.
+func (b *IssueBot) displayNames(r *http.Request, gnames []string) []string {
+	team := r.PostForm.Get("team_id")
+	var names []string
+	for _, g := range gnames {
+		b.Lock()
+		id, ok := b.g2s[teamKey{team, g}]
+		sname := ""
+		for s, gn := range b.byName {
+			if gn == g {
+				sname = s
+			}
+		}
+		b.Unlock()
+		switch {
+		case ok:
+			g = "@" + b.slackName(team, id)
+		case sname != "":
+			g = "@" + sname
+		}
+		names = append(names, g)
+	}
+	return names
+}
 
-	inum, ok := parseSimpleNumCmd(w, r, f)
-	if !ok {
+// Maximum number of results to return from a grep
+const maxGrepResults = 10
+
+func grepIssues(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
+	var resp *response
+
+	msg := ""
+	defer func(){writeResponse(w, resp, msg)}()
+
+	if b.mirror == nil {
+		msg = "Issue search is not enabled"
+		return
+	}
+	b.Lock()
+	repo := b.teamRepo(r)
+	b.Unlock()
+	if repo != b.repo {
+		msg = fmt.Sprintf("Issue search is only available for %s", b.repo)
 		return
 	}
+	last := b.mirror.LastSync()
+	if last.IsZero() {
+		msg = "The issue mirror has not been loaded yet"
+		return
+	}
+
+	// the mirror parses quoted phrases itself
+	query := a.Text("TERMS")
+	issues := b.mirror.Search(query, maxGrepResults)
+	if len(issues) == 0 {
+		msg = fmt.Sprintf("No issues match %q", query)
+		return
+	}
+
+	age := time.Since(last).Round(time.Second)
+	msg = fmt.Sprintf("Top %d issues matching %q (synced %s ago):\n", len(issues), query, age)
+	resp = newResponse("").add(headerBlock(fmt.Sprintf("Issues matching %q", query)))
+	labels := b.mirror.Labels()
+	for _, iss := range issues {
+		msg += fmt.Sprintf("\t%d [%s] %q %s\n", iss.Number, iss.State, iss.Title, iss.HTMLURL)
+		resp.add(issueListBlocks(iss, labels)...)
+	}
+	resp.add(contextBlock(fmt.Sprintf("%d results from %s, synced %s ago", len(issues), b.repo, age)))
+	resp.Text = msg
+	resp.ResponseType = b.responseType(r, "grep")
+}
+
+func closeIssue(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
+	var resp *response
+
+	log := log.WithField("method", "closeIssue")
+	msg := ""
+	defer func(){writeResponse(w, resp, msg)}()
 
-	// XXX TODO: make this a channel-wide announcement
-	msg = fmt.Sprintf("Issue %d successfully closed", inum)
+	inum := a.Num("NUM")
 
 	b.Lock()
-	err := b.agent.CloseIssue(inum)
+	agent := b.teamAgent(r)
 	b.Unlock()
+	err := agent.CloseIssue(inum)
 
 	if err != nil {
 		msg = fmt.Sprintf("Unable to close issue %d", inum)
 		log.Info("Unable to close issue ", inum, ": ", err)
+		return
 	}
+	resp = b.announce(r, "close", agent, inum, "closed", "")
 }
 
-func reopenIssue(b *IssueBot, w http.ResponseWriter, r *http.Request, f []string) {
+func reopenIssue(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
+	var resp *response
+
 	log := log.WithField("method", "reopenIssue")
-	msg := "usage: /issue reopen NUMBER"
-	defer func(){w.Write([]byte(msg))}()
+	msg := ""
+	defer func(){writeResponse(w, resp, msg)}()
 
-	inum, ok := parseSimpleNumCmd(w, r, f)
-	if !ok {
-		return
-	}
+	inum := a.Num("NUM")
 
-	// XXX TODO: make this a channel-wide announcement
-	msg = fmt.Sprintf("Issue %d successfully reopened", inum)
 	b.Lock()
-	err := b.agent.OpenIssue(inum)
+	agent := b.teamAgent(r)
 	b.Unlock()
+	err := agent.OpenIssue(inum)
 
 	if err != nil {
 		msg = fmt.Sprintf("Unable to reopen issue %d", inum)
-		log.Infof("Unable to reopen issue ", inum, ": ", err)
+		log.Info("Unable to reopen issue ", inum, ": ", err)
+		return
 	}
+	resp = b.announce(r, "reopen", agent, inum, "reopened", "")
 }
 
-func assignIssue(b *IssueBot, w http.ResponseWriter, r *http.Request, f []string) {
+func assignIssue(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
+	var resp *response
+
 	log := log.WithField("method", "assignIssue")
-	msg := "usage: /issue assign NUM [@SLACKNAME|@me|GITHUBNAME]"
-	defer func(){w.Write([]byte(msg))}()
+	msg := ""
+	defer func(){writeResponse(w, resp, msg)}()
 
-	if len(f) != 2 {
+	inum := a.Num("NUM")
+	b.Lock()
+	agent := b.teamAgent(r)
+	b.Unlock()
+	gname, name, err := b.resolveUser(r, a.Get("USER"))
+	if err != nil {
+		msg = err.Error()
 		return
 	}
-	inum, err := strconv.Atoi(f[0])
+
+	err = agent.AssignIssue(inum, gname)
 	if err != nil {
+		msg = fmt.Sprintf("Unable to assign issue %d to %q", inum, name)
+		log.Info("Unable to assign issue ", inum, " to ", gname, ": ", err)
 		return
 	}
+	resp = b.announce(r, "assign", agent, inum, "assigned", " to "+escape(name))
+}
 
-	b.Lock()
-	defer b.Unlock()
-
-	name := f[1]
-	gname := name
-	if gname == "@me" {
-		name, err = getField("user_name", r)
-		if err != nil {
-			reqErr(log, w, err)
-			return
+// Resolve a user argument of the form <@USERID>, @SLACKNAME, @me or
+// GITHUBNAME to a github user name.  Also returns the name to display for
+// the user.  Slack sends mentions as <@USERID|NAME> when the command has
+// "Escape channels, users, and links" turned on.
+func (b *IssueBot) resolveUser(r *http.Request, name string) (string, string, error) {
+	team := r.PostForm.Get("team_id")
+	var id string
+	switch m := mentionRe.FindStringSubmatch(name); {
+	case name == "@me":
+		id = r.PostForm.Get("user_id")
+		if id == "" {
+			return "", "", fmt.Errorf("Error:  malformed request")
 		}
-		name = "@" + name
+	case m != nil:
+		id = m[1]
+	case strings.HasPrefix(name, "@"):
+		return b.resolveSlackName(team, name[1:])
+	default:
+		return name, name, nil
 	}
-	if name[0] == '@' {
-		s, ok := b.s2g[name[1:]]
-		if !ok {
-			msg = fmt.Sprintf("%q is not registered", name)
-			return
+
+	b.Lock()
+	gu, ok := b.s2g[teamKey{team, id}]
+	b.Unlock()
+	display := "@" + b.slackName(team, id)
+	if !ok {
+		b.Lock()
+		gname, named := b.byName[r.PostForm.Get("user_name")]
+		b.Unlock()
+		if name == "@me" && named {
+			return "", "", fmt.Errorf("%q is not registered.  If you are github user %q confirm it with '/issue register %s'.",
+				display, gname, gname)
 		}
-		gname = s
+		return "", "", fmt.Errorf("%q is not registered", display)
 	}
+	return gu.login, display, nil
+}
 
-	// XXX TODO: make this a channel-wide announcement
-	msg = fmt.Sprintf("Issue %d is now assigned to %s", inum, name)
-	err = b.agent.AssignIssue(inum, gname)
+func unassignIssue(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
+	var resp *response
+
+	log := log.WithField("method", "unassignIssue")
+	msg := ""
+	defer func(){writeResponse(w, resp, msg)}()
+
+	inum := a.Num("NUM")
+
+	b.Lock()
+	agent := b.teamAgent(r)
+	b.Unlock()
+	err := agent.UnassignIssue(inum)
 
 	if err != nil {
-		msg = fmt.Sprintf("Unable to assign issue %d to %q", inum, name)
-		log.Info("Unable to assign issue ", inum, " to ", gname, ": ", err)
+		msg = fmt.Sprintf("Unable to unassign issue %d", inum)
+		log.Info("Unable to unassign issue ", inum, ": ", err)
+		return
 	}
+	resp = b.announce(r, "unassign", agent, inum, "unassigned", "")
 }
 
-func unassignIssue(b *IssueBot, w http.ResponseWriter, r *http.Request, f []string) {
-	log := log.WithField("method", "unassignIssue")
-	msg := "usage: /issue unassign NUMBER"
-	defer func(){w.Write([]byte(msg))}()
+// /issue label NUM LABEL...
+func labelIssue(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
+	var resp *response
 
-	inum, ok := parseSimpleNumCmd(w, r, f)
-	if !ok {
-		return
-	}
+	log := log.WithField("method", "labelIssue")
+	msg := ""
+	defer func(){writeResponse(w, resp, msg)}()
 
-	// XXX TODO: make this a channel-wide announcement
-	msg = fmt.Sprintf("Issue %d is no longer assigned to anyone", inum)
+	inum := a.Num("NUM")
 	b.Lock()
-	err := b.agent.UnassignIssue(inum)
+	agent := b.teamAgent(r)
 	b.Unlock()
+	issue, err := agent.GetIssue(inum)
+	if err != nil {
+		msg = fmt.Sprintf("Unable to find issue %d", inum)
+		log.Info("Unable to find issue ", inum, ": ", err)
+		return
+	}
 
+	labels := []string{}
+	for _, l := range issue.Labels {
+		labels = append(labels, l.Name)
+	}
+	for _, arg := range a.List("LABEL") {
+		remove := strings.HasPrefix(arg, "-")
+		name := strings.TrimLeft(arg, "+-")
+		if name == "" {
+			msg = fmt.Sprintf("Invalid label %q", arg)
+			return
+		}
+		kept := []string{}
+		for _, l := range labels {
+			if !strings.EqualFold(l, name) {
+				kept = append(kept, l)
+			}
+		}
+		if !remove {
+			kept = append(kept, name)
+		}
+		labels = kept
+	}
+
+	err = agent.SetLabels(inum, labels)
 	if err != nil {
-		msg = fmt.Sprintf("Unable to unassign issue %d", inum)
-		log.Info("Unable to unassign issue ", inum, ": ", err)
+		msg = fmt.Sprintf("Unable to label issue %d", inum)
+		log.Info("Unable to label issue ", inum, ": ", err)
+		return
 	}
+	resp = b.announce(r, "label", agent, inum, "labeled", ":  "+escape(strings.Join(a.List("LABEL"), " ")))
 }
 
-func registerUser(b *IssueBot, w http.ResponseWriter, r *http.Request, f []string) {
+func registerUser(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
 	log := log.WithField("method", "registerUser")
-	msg := "usage: /issue register GITHUBUSER"
+	msg := ""
 	defer func(){w.Write([]byte(msg))}()
 
-	sname, err := getField("user_name", r)
-	if err != nil {
+	if _, err := getField("user_id", r); err != nil {
 		reqErr(log, w, err)
 		return
 	}
-	if len(f) != 1 {
+
+	if !a.Has("GITHUBUSER") {
+		login, err := b.finishRegistration(r)
+		if err != nil {
+			msg = err.Error()
+			return
+		}
+		msg = fmt.Sprintf("You are now registered as github user %q\n", login)
 		return
 	}
-
-	msg = fmt.Sprintf("You are now registered as github user %q\n", f[0])
-	b.Lock()
-	defer b.Unlock()
-	if !b.AddUserMap(sname, f[0]) {
-		msg = fmt.Sprintf("Registration conflict")
+	login := a.Get("GITHUBUSER")
+	code, err := b.startRegistration(r, login)
+	if err != nil {
+		msg = err.Error()
+		return
 	}
+	msg = fmt.Sprintf("To show that you are github user %q, put\n\t%s\n" +
+		"in your github profile bio or in a public gist and then run '/issue register' " +
+		"within the hour.  You can remove it once you are registered.", login, code)
 }
 
-func getAlias(b *IssueBot, w http.ResponseWriter, r *http.Request, f []string) {
+func getAlias(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
 	log := log.WithField("method", "getAlias")
-	msg := "usage: /issue get-alias"
+	msg := ""
 	defer func(){w.Write([]byte(msg))}()
 
-	sname, err := getField("user_name", r)
-	if err != nil {
+	if _, err := getField("user_id", r); err != nil {
 		reqErr(log, w, err)
 		return
 	}
 
 	b.Lock()
 	defer b.Unlock()
-	gname, ok := b.s2g[sname]
-	if !ok {
+	gu, ok := b.s2g[requester(r)]
+	switch {
+	case !ok:
 		msg = fmt.Sprintf("You are currently not registered as a github user")
-	} else {
-		msg = fmt.Sprintf("You are currently registered as github user %q", gname)
+	case gu.id != 0:
+		msg = fmt.Sprintf("You are currently registered as github user %q (ID %d)", gu.login, gu.id)
+	default:
+		msg = fmt.Sprintf("You are currently registered as github user %q", gu.login)
 	}
 }
 
-func unregisterUser(b *IssueBot, w http.ResponseWriter, r *http.Request, f []string) {
+func unregisterUser(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
 	log := log.WithField("method", "unregisterUser")
-	msg := "usage: /issue unregister"
+	msg := ""
 	defer func(){w.Write([]byte(msg))}()
 
-	sname, err := getField("user_name", r)
+	id, err := getField("user_id", r)
 	if err != nil {
 		reqErr(log, w, err)
 		return
@@ -337,7 +720,7 @@ func unregisterUser(b *IssueBot, w http.ResponseWriter, r *http.Request, f []str
 
 	b.Lock()
 	defer b.Unlock()
-	b.DelUserBySlack(sname)
+	b.DelUser(r.PostForm.Get("team_id"), id)
 	msg = "Registration cleared"
 }
 
@@ -357,14 +740,3 @@ func getField(field string, r *http.Request) (string, error) {
 
 }
 
-func parseSimpleNumCmd(w http.ResponseWriter, r *http.Request, f []string) (int, bool) {
-	if len(f) != 1 {
-		return -1, false
-	}
-	inum, err := strconv.Atoi(f[0])
-	if err != nil {
-		return -1, false
-	}
-	return inum, true
-}
-
@@ -0,0 +1,248 @@
+package slack
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ctelfer-docker/slkiss/github"
+	"github.com/ctelfer-docker/slkiss/githubtest"
+)
+
+const testRepo = "o/r"
+
+// A bot talking to a fake github and a fake slack Web API
+type testBot struct {
+	*IssueBot
+	gh    *githubtest.Server
+	slack *httptest.Server
+	posts chan string // messages posted to response_urls
+}
+
+func newTestBot(t *testing.T) *testBot {
+	tb := &testBot{gh: githubtest.NewServer(), posts: make(chan string, 10)}
+	tb.gh.AddUser("alice")
+	tb.gh.AddUser("bob")
+	tb.gh.AddLabel(testRepo, "bug", "d73a4a")
+	tb.gh.AddLabel(testRepo, "docs", "0075ca")
+	tb.gh.AddIssue(testRepo, &github.Issue{Title: "Crash on start"})
+	tb.gh.AddIssue(testRepo, &github.Issue{Title: "Crash on exit"})
+	tb.gh.AddIssue(testRepo, &github.Issue{Title: "Typo in the docs"})
+	tb.gh.AddIssue(testRepo, &github.Issue{Title: "Add crash reporting", PullRequest: &github.PullRequestRef{}})
+
+	tb.slack = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/response" {
+			tb.posts <- string(body)
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(`{"ok":true,"user":{"id":"U1","name":"alice"}}`))
+	}))
+
+	tb.IssueBot = NewIssueBot("127.0.0.1:0", testRepo)
+	tb.SetSlackURL(tb.slack.URL + "/")
+	tb.SetGithubURL(tb.gh.URL + "/")
+	tb.SetGithubAuth("bot-token")
+	tb.SetBotToken("xoxb-test")
+	if err := tb.SetConfig(&Config{DefaultRole: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	return tb
+}
+
+func (tb *testBot) close() {
+	tb.gh.Close()
+	tb.slack.Close()
+}
+
+// Send '/issue TEXT' from user U1 and return the reply.  Commands are
+// run synchronously unless a response_url is given.
+func (tb *testBot) command(text string, responseURL string) string {
+	form := url.Values{
+		"team_id":      {"T1"},
+		"user_id":      {"U1"},
+		"user_name":    {"alice"},
+		"channel_id":   {"C1"},
+		"text":         {text},
+		"response_url": {responseURL},
+	}
+	r := httptest.NewRequest(http.MethodPost, "/issue", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	tb.mux.ServeHTTP(w, r)
+	return w.Body.String()
+}
+
+func (tb *testBot) run(t *testing.T, text string, want string) string {
+	reply := tb.command(text, "")
+	if !strings.Contains(reply, want) {
+		t.Fatalf("/issue %s: reply %q does not contain %q", text, reply, want)
+	}
+	return reply
+}
+
+func (tb *testBot) issue(t *testing.T, num int) *github.Issue {
+	iss := tb.gh.Issue(testRepo, num)
+	if iss == nil {
+		t.Fatalf("issue %d not found", num)
+	}
+	return iss
+}
+
+func labels(iss *github.Issue) string {
+	var names []string
+	for _, l := range iss.Labels {
+		names = append(names, l.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+var confirmRe = regexp.MustCompile("/issue bulk confirm ([0-9a-f]+)")
+
+func TestFind(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+
+	tb.run(t, "find 2", `Issue 2: \"Crash on exit\"`)
+	tb.run(t, "find 99", "Unable to find issue 99")
+	tb.run(t, "find", "usage: /issue find NUM")
+}
+
+func TestAsyncReply(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+
+	if reply := tb.command("close 1", tb.slack.URL+"/response"); reply != ackMessage {
+		t.Fatalf("async reply %q, expected %q", reply, ackMessage)
+	}
+	select {
+	case msg := <-tb.posts:
+		if !strings.Contains(msg, `"in_channel"`) || !strings.Contains(msg, "closed \\u003chttps://github.com/o/r/issues/1|#1") {
+			t.Errorf("unexpected result %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no result posted to the response_url")
+	}
+	if st := tb.issue(t, 1).State; st != "closed" {
+		t.Errorf("issue 1 is %s after close", st)
+	}
+}
+
+func TestChangeAndUndo(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		num    int
+		after  func(*github.Issue) string
+		want   string // after the command
+		undone string // after undo
+	}{
+		{"close", "close 1", 1, func(i *github.Issue) string { return i.State }, "closed", "open"},
+		{"assign", "assign 2 bob", 2, func(i *github.Issue) string { return strings.Join(assigneeLogins(i), ",") }, "bob", ""},
+		{"label", "label 3 docs", 3, labels, "docs", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTestBot(t)
+			defer tb.close()
+
+			tb.command(tt.text, "")
+			if got := tt.after(tb.issue(t, tt.num)); got != tt.want {
+				t.Fatalf("after %q issue %d has %q, expected %q", tt.text, tt.num, got, tt.want)
+			}
+			tb.run(t, "undo", "Undid `/issue "+tt.text+"`")
+			if got := tt.after(tb.issue(t, tt.num)); got != tt.undone {
+				t.Errorf("after undo issue %d has %q, expected %q", tt.num, got, tt.undone)
+			}
+			tb.run(t, "undo", "You have no recent changes to undo")
+		})
+	}
+}
+
+func TestUndoConflict(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+
+	tb.command("label 1 bug", "")
+	// someone else changes the issue afterwards
+	a := github.NewRepoAgentURL(tb.gh.URL+"/", testRepo)
+	a.SetToken("other")
+	if err := a.LabelIssue(1, "docs"); err != nil {
+		t.Fatal(err)
+	}
+	tb.run(t, "undo", "issue 1 was changed again")
+	if got := labels(tb.issue(t, 1)); got != "bug,docs" {
+		t.Errorf("labels %q after a refused undo", got)
+	}
+}
+
+func TestBulk(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+
+	preview := tb.run(t, "bulk label bug crash", "This will add label \"bug\" to 2 issues: 1, 2")
+	for n := 1; n <= 4; n++ {
+		if got := labels(tb.issue(t, n)); got != "" {
+			t.Fatalf("issue %d labeled %q before confirmation", n, got)
+		}
+	}
+	token := confirmRe.FindStringSubmatch(preview)[1]
+	tb.run(t, "bulk confirm nothere", `No pending bulk operation "nothere"`)
+	tb.run(t, "bulk confirm "+token, "Changed 2 of 2 issues")
+	for n, want := range map[int]string{1: "bug", 2: "bug", 3: "", 4: ""} {
+		if got := labels(tb.issue(t, n)); got != want {
+			t.Errorf("issue %d labeled %q after bulk label, expected %q", n, got, want)
+		}
+	}
+	tb.run(t, "bulk confirm "+token, "No pending bulk operation")
+
+	// issue 2 changes again so undo must leave it alone
+	a := github.NewRepoAgentURL(tb.gh.URL+"/", testRepo)
+	a.SetToken("other")
+	if err := a.CloseIssue(2); err != nil {
+		t.Fatal(err)
+	}
+	reply := tb.run(t, "bulk undo "+token, "Reverted 1 of 2 issues")
+	if !strings.Contains(reply, "Skipped:\n\t2: changed again") {
+		t.Errorf("issue 2 not reported as skipped: %q", reply)
+	}
+	if got := labels(tb.issue(t, 1)); got != "" {
+		t.Errorf("issue 1 labeled %q after bulk undo", got)
+	}
+	if got := labels(tb.issue(t, 2)); got != "bug" {
+		t.Errorf("changed issue 2 labeled %q after bulk undo", got)
+	}
+	tb.run(t, "bulk undo "+token, "No bulk operation")
+}
+
+func TestBulkClose(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+
+	preview := tb.run(t, "bulk close state=open", "This will close 3 issues: 1, 2, 3")
+	tb.run(t, "bulk confirm "+confirmRe.FindStringSubmatch(preview)[1], "Changed 3 of 3 issues")
+	for n, want := range map[int]string{1: "closed", 2: "closed", 3: "closed", 4: "open"} {
+		if st := tb.issue(t, n).State; st != want {
+			t.Errorf("issue %d is %s after bulk close, expected %s", n, st, want)
+		}
+	}
+}
+
+func TestRejectedCommands(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+
+	tb.run(t, "frobnicate 1", `unknown command "frobnicate"`)
+	tb.run(t, "assign 1", "usage: /issue assign NUM USER")
+	tb.run(t, `find "1`, "unterminated quote")
+	for _, req := range tb.gh.Requests() {
+		if !strings.HasPrefix(req, "GET") {
+			t.Errorf("rejected command changed github: %s", req)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ctelfer-docker/slkiss/websocket"
+)
+
+// Socket Mode connection limits.  See:
+//   https://api.slack.com/apis/connections/socket
+const (
+	socketBackoff    = time.Second     // delay before the first reconnect
+	socketMaxBackoff = 2 * time.Minute // longest delay between reconnects
+	socketIdle       = 2 * time.Minute // longest silence before reconnecting
+)
+
+// A message from slack over a Socket Mode connection
+type socketEnvelope struct {
+	Type                   string          `json:"type"`
+	EnvelopeID             string          `json:"envelope_id"`
+	Payload                json.RawMessage `json:"payload"`
+	AcceptsResponsePayload bool            `json:"accepts_response_payload"`
+	Reason                 string          `json:"reason"`
+}
+
+// An acknowledgement of an envelope
+type socketAck struct {
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+}
+
+// Where each kind of envelope is handled in the HTTP mux
+var socketPaths = map[string]string{
+	"slash_commands": "/issue",
+	"interactive":    "/slack/interactive",
+	"events_api":     "/slack/events",
+}
+
+// Marks requests that arrived over Socket Mode
+type socketKey struct{}
+
+// Returned when slack asks for the connection to be replaced
+var errSocketRefresh = fmt.Errorf("slack asked for a new connection")
+
+// Receive slash commands, interactions and events from slack over a
+// Socket Mode websocket instead of HTTP so that the bot doesn't need a
+// public URL.  token is an app-level token with the connections:write
+// scope.  The connection is opened when the bot is Run().
+func (b *IssueBot) SetAppToken(token string) {
+	b.appToken = token
+}
+
+// Returns true if a request came over Socket Mode rather than HTTP.  Such
+// requests aren't signed since the connection itself is authenticated.
+func fromSocket(r *http.Request) bool {
+	return r.Context().Value(socketKey{}) != nil
+}
+
+// Keep a Socket Mode connection open until stop is closed, reconnecting
+// with exponential backoff when the connection fails.
+func (b *IssueBot) socketLoop(stop <-chan struct{}) {
+	log := log.WithField("method", "socketLoop")
+	delay := socketBackoff
+	for {
+		start := time.Now()
+		err := b.runSocket(stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if err == errSocketRefresh {
+			log.Info("Refreshing Socket Mode connection")
+			delay = socketBackoff
+			continue
+		}
+		// a connection that stayed up a while was working so don't hold
+		// earlier failures against it
+		if time.Since(start) > socketMaxBackoff {
+			delay = socketBackoff
+		}
+		log.Warnf("Socket Mode connection lost: %s (reconnecting in %s)", err, delay)
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > socketMaxBackoff {
+			delay = socketMaxBackoff
+		}
+	}
+}
+
+// Open one Socket Mode connection and serve it until it closes.
+func (b *IssueBot) runSocket(stop <-chan struct{}) error {
+	wsurl, err := b.slackapi.WithToken(b.appToken).OpenConnection()
+	if err != nil {
+		return err
+	}
+	c, err := websocket.Dial(wsurl, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			c.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		c.SetReadDeadline(time.Now().Add(socketIdle))
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var env socketEnvelope
+		if err = json.Unmarshal(data, &env); err != nil {
+			log.WithField("method", "runSocket").Warn("Malformed Socket Mode message: ", err)
+			continue
+		}
+		switch env.Type {
+		case "hello":
+			log.WithField("method", "runSocket").Info("Socket Mode connected")
+		case "disconnect":
+			if env.Reason == "link_disabled" {
+				return fmt.Errorf("Socket Mode was turned off for the app")
+			}
+			return errSocketRefresh
+		default:
+			go b.serveEnvelope(c, &env)
+		}
+	}
+}
+
+// Run an envelope through the same handlers as HTTP requests and
+// acknowledge it with the handler's response.
+func (b *IssueBot) serveEnvelope(c *websocket.Conn, env *socketEnvelope) {
+	log := log.WithField("method", "serveEnvelope")
+	ack := &socketAck{EnvelopeID: env.EnvelopeID}
+	defer func() {
+		data, _ := json.Marshal(ack)
+		if err := c.WriteMessage(websocket.TextMessage, data); err != nil {
+			log.Warn("Unable to acknowledge ", env.Type, " envelope: ", err)
+		}
+	}()
+
+	r, err := socketRequest(env)
+	if err != nil {
+		log.Warn("Unable to handle ", env.Type, " envelope: ", err)
+		return
+	}
+	bw := newBufferedWriter()
+	b.mux.ServeHTTP(bw, r)
+	if bw.status != http.StatusOK {
+		log.Warnf("Handling %s envelope failed: %d %s", env.Type, bw.status, strings.TrimSpace(bw.body.String()))
+		return
+	}
+	if env.AcceptsResponsePayload && bw.body.Len() > 0 {
+		ack.Payload = bw.message()
+	}
+}
+
+// Build the HTTP request that slack would have sent for an envelope.
+func socketRequest(env *socketEnvelope) (*http.Request, error) {
+	path, ok := socketPaths[env.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown envelope type %q", env.Type)
+	}
+	body := string(env.Payload)
+	ctype := "application/json"
+	switch env.Type {
+	case "slash_commands":
+		var fields map[string]interface{}
+		if err := json.Unmarshal(env.Payload, &fields); err != nil {
+			return nil, err
+		}
+		form := url.Values{}
+		for k, v := range fields {
+			form.Set(k, fmt.Sprint(v))
+		}
+		body = form.Encode()
+		ctype = "application/x-www-form-urlencoded"
+	case "interactive":
+		body = url.Values{"payload": {body}}.Encode()
+		ctype = "application/x-www-form-urlencoded"
+	}
+
+	r, err := http.NewRequest("POST", path, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", ctype)
+	r.RemoteAddr = "socket-mode"
+	return r.WithContext(context.WithValue(r.Context(), socketKey{}, env.EnvelopeID)), nil
+}
@@ -0,0 +1,235 @@
+package slack
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ctelfer-docker/slkiss/github"
+)
+
+// The sweeper tags its warning comments with this marker so that it can
+// find out when it marked an issue stale.
+const staleMarker = "<!-- issuebot:stale -->"
+
+// Changes to issue timestamps within this long after the sweeper marks
+// an issue are assumed to be caused by the marking itself.
+const staleSlop = 2 * time.Minute
+
+// What the sweeper will do (or did) to one issue
+type sweepAction struct {
+	repo   string
+	issue  *github.Issue
+	action string // "mark", "close" or "unmark"
+	err    error
+}
+
+// Run the stale issue sweeper on every configured repository every
+// SweepInterval until stop is closed.
+func (b *IssueBot) sweepLoop(stop <-chan struct{}) {
+	log := log.WithField("method", "sweepLoop")
+	b.Lock()
+	interval := time.Duration(b.config.SweepInterval)
+	b.Unlock()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		// wait before the first sweep so admins can preview it after
+		// a restart with '/issue admin sweep --dry-run'
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		rec := b.startAudit(nil, "sweep", "scheduled sweep")
+		actions := b.sweep(rec.observe, false)
+		for _, a := range actions {
+			if a.err != nil {
+				log.Warnf("Unable to %s %s#%d: %s", a.action, a.repo, a.issue.Number, a.err)
+			} else {
+				log.Infof("Sweeper: %s %s#%d", a.action, a.repo, a.issue.Number)
+			}
+		}
+		if len(actions) > 0 {
+			b.finishAudit(rec, fmt.Sprintf("swept %d issues", len(actions)))
+		}
+	}
+}
+
+// Sweep all configured repositories.  If dryRun is true then work out
+// what would be done without changing anything.  Changes are passed to
+// observe if it isn't nil.
+func (b *IssueBot) sweep(observe func(*github.Call), dryRun bool) []*sweepAction {
+	log := log.WithField("method", "sweep")
+	b.Lock()
+	policies := b.config.Sweep
+	agents := make([]*github.Agent, len(policies))
+	for i, p := range policies {
+		agents[i] = b.repoAgent(p.Repo).WithObserver(observe)
+	}
+	b.Unlock()
+
+	var actions []*sweepAction
+	now := time.Now()
+	for i, p := range policies {
+		pa, err := planSweep(agents[i], p, now)
+		if err != nil {
+			log.Warn("Unable to sweep ", p.Repo, ": ", err)
+			continue
+		}
+		if !dryRun {
+			for _, a := range pa {
+				a.err = applySweep(agents[i], p, a)
+			}
+		}
+		actions = append(actions, pa...)
+	}
+	return actions
+}
+
+// Work out what to do with the open issues in one repository.
+func planSweep(a *github.Agent, p *SweepPolicy, now time.Time) ([]*sweepAction, error) {
+	issues, err := a.FetchIssues(map[string]string{
+		"state":     "open",
+		"sort":      "updated",
+		"direction": "asc",
+		"per_page":  "100",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []*sweepAction
+	for _, iss := range issues {
+		// github lists pull requests along with the issues
+		if iss.IsPullRequest() || hasAnyLabel(iss, p.ExemptLabels) {
+			continue
+		}
+		act := ""
+		if !hasAnyLabel(iss, []string{p.Label}) {
+			if now.Sub(iss.UpdatedAt) >= time.Duration(p.StaleAfter) {
+				act = "mark"
+			}
+		} else {
+			comments, err := a.FetchComments(iss.Number)
+			if err != nil {
+				return nil, err
+			}
+			act = staleState(iss, comments, p, now)
+		}
+		if act != "" {
+			actions = append(actions, &sweepAction{repo: p.Repo, issue: iss, action: act})
+		}
+	}
+	return actions, nil
+}
+
+// Decide what to do with an issue that already has the stale label.
+func staleState(iss *github.Issue, comments []*github.Comment, p *SweepPolicy, now time.Time) string {
+	var marked time.Time
+	active := false
+	for _, c := range comments {
+		if strings.Contains(c.Body, staleMarker) {
+			marked = c.CreatedAt
+			active = false
+		} else if !marked.IsZero() {
+			active = true
+		}
+	}
+	switch {
+	case marked.IsZero():
+		// labeled by hand:  start the grace period now
+		return "mark"
+	case active || iss.UpdatedAt.Sub(marked) > staleSlop:
+		return "unmark"
+	case now.Sub(marked) >= time.Duration(p.CloseAfter):
+		return "close"
+	}
+	return ""
+}
+
+// Carry out one sweep action.
+func applySweep(a *github.Agent, p *SweepPolicy, act *sweepAction) error {
+	num := act.issue.Number
+	switch act.action {
+	case "mark":
+		if err := a.LabelIssue(num, p.Label); err != nil {
+			return err
+		}
+		return a.CommentIssue(num, p.Warning+"\n\n"+staleMarker)
+	case "unmark":
+		return a.UnlabelIssue(num, p.Label)
+	case "close":
+		if err := a.CommentIssue(num, p.CloseComment); err != nil {
+			return err
+		}
+		return a.CloseIssue(num)
+	}
+	return fmt.Errorf("unknown sweep action %q", act.action)
+}
+
+func hasAnyLabel(iss *github.Issue, labels []string) bool {
+	for _, l := range iss.Labels {
+		for _, name := range labels {
+			if strings.EqualFold(l.Name, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// /issue admin sweep [--dry-run]
+func adminSweep(b *IssueBot, r *http.Request, a *Args) string {
+	dryRun := a.Has("dry-run")
+
+	b.Lock()
+	n := len(b.config.Sweep)
+	b.Unlock()
+	if n == 0 {
+		return "No sweep policies are configured"
+	}
+
+	var observe func(*github.Call)
+	if rec := auditOf(r); rec != nil {
+		observe = rec.observe
+	}
+	actions := b.sweep(observe, dryRun)
+	if len(actions) == 0 {
+		return "No issues need sweeping"
+	}
+	verbs := map[string]string{"mark": "mark stale", "close": "close", "unmark": "unmark"}
+	msg := "Sweep results:"
+	if dryRun {
+		msg = "A sweep would:"
+	}
+	for _, a := range actions {
+		status := ""
+		if a.err != nil {
+			status = " (failed: " + a.err.Error() + ")"
+		}
+		msg += fmt.Sprintf("\n\t%s %s#%d %q%s", verbs[a.action], a.repo, a.issue.Number, a.issue.Title, status)
+	}
+	return msg
+}
+
+// Admin subcommands
+var adminHandlers = map[string]func(*IssueBot, *http.Request, *Args) string{
+	"sweep":   adminSweep,
+	"audit":   adminAudit,
+	"alias":   adminAlias,
+	"unalias": adminUnalias,
+}
+
+// Arguments of the admin subcommands
+var adminArgs = &schema{subs: []*schema{
+	{name: "sweep", flags: []*flagSpec{{name: "dry-run", short: "n"}}},
+	auditArgs,
+	aliasArgs,
+	unaliasArgs,
+}}
+
+func adminCommand(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
+	w.Write([]byte(adminHandlers[a.sub](b, r, a)))
+}
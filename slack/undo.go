@@ -0,0 +1,169 @@
+package slack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ctelfer-docker/slkiss/github"
+)
+
+// A user's most recent change that '/issue undo' can revert
+type undoAction struct {
+	repo  string
+	text  string // the command that made the change if known
+	snaps []*github.Snapshot
+	made  time.Time
+}
+
+// Where the changes made while handling a request are collected so that
+// every command of a command line is undone together
+type undoKey struct{}
+
+type undoGroup struct {
+	act *undoAction
+}
+
+// Return a request whose changes are collected into one undoAction.
+func withUndoGroup(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), undoKey{}, &undoGroup{}))
+}
+
+// Return a function that saves the snapshots an agent takes as the most
+// recent change of the user who sent a request.  Every change made while
+// handling the request is undone together.
+func (b *IssueBot) undoRecorder(r *http.Request, repo string) func(*github.Snapshot) {
+	u := requester(r)
+	text := r.PostForm.Get("text")
+	grp, ok := r.Context().Value(undoKey{}).(*undoGroup)
+	if !ok {
+		grp = &undoGroup{}
+	}
+	return func(snap *github.Snapshot) {
+		b.Lock()
+		defer b.Unlock()
+		if grp.act == nil {
+			grp.act = &undoAction{repo: repo, text: text, made: time.Now()}
+			b.undo[u] = grp.act
+		}
+		grp.act.snaps = append(grp.act.snaps, snap)
+		b.pruneUndo()
+	}
+}
+
+// Drop changes that can no longer be undone.  Must be called with the
+// lock held.
+func (b *IssueBot) pruneUndo() {
+	window := time.Duration(b.config.UndoWindow)
+	for u, act := range b.undo {
+		if time.Since(act.made) > window {
+			delete(b.undo, u)
+		}
+	}
+}
+
+// /issue undo
+//
+// Reverts the user's most recent change to issues.  Nothing is reverted
+// if anything else has changed any of the issues since:  undoing then
+// could silently throw away someone else's work.
+func undoCommand(b *IssueBot, w http.ResponseWriter, r *http.Request, a *Args) {
+	log := log.WithField("method", "undoCommand")
+	msg := ""
+	defer func() { w.Write([]byte(msg)) }()
+
+	if _, err := getField("user_id", r); err != nil {
+		reqErr(log, w, err)
+		msg = ""
+		return
+	}
+
+	u := requester(r)
+	b.Lock()
+	b.pruneUndo()
+	act, ok := b.undo[u]
+	if !ok {
+		b.Unlock()
+		msg = "You have no recent changes to undo"
+		return
+	}
+	agent := auditAgent(r, b.repoAgent(act.repo))
+	b.Unlock()
+
+	what := "your last change"
+	if act.text != "" {
+		what = "`/issue " + act.text + "`"
+	}
+	// check every issue before changing any so that an undo is all or
+	// nothing
+	last := make(map[int]*github.Snapshot)
+	for _, snap := range act.snaps {
+		last[snap.Issue] = snap
+	}
+	for num, snap := range last {
+		iss, err := agent.GetIssue(num)
+		if err != nil {
+			log.Info("Unable to read issue ", num, ": ", err)
+			msg = fmt.Sprintf("Unable to undo %s:  can't read issue %d", what, num)
+			return
+		}
+		if !iss.UpdatedAt.Equal(snap.UpdatedAt) {
+			msg = fmt.Sprintf("Not undoing %s:  issue %d was changed again at %s "+
+				"and undoing would overwrite that", what, num, iss.UpdatedAt.Format(time.RFC1123))
+			return
+		}
+	}
+
+	b.Lock()
+	if b.undo[u] == act {
+		delete(b.undo, u)
+	}
+	b.Unlock()
+
+	var done []string
+	for i := len(act.snaps) - 1; i >= 0; i-- {
+		snap := act.snaps[i]
+		if err := agent.Restore(snap); err != nil {
+			log.Info("Unable to restore issue ", snap.Issue, ": ", err)
+			msg = fmt.Sprintf("Unable to undo %s on issue %d", what, snap.Issue)
+			if len(done) > 0 {
+				msg += "\nAlready restored: " + strings.Join(done, ", ")
+			}
+			return
+		}
+		done = append(done, describeSnapshot(snap))
+	}
+	msg = fmt.Sprintf("Undid %s:\n\t%s", what, strings.Join(done, "\n\t"))
+}
+
+// Describe what restoring a snapshot did.
+func describeSnapshot(snap *github.Snapshot) string {
+	var parts []string
+	for _, f := range snap.Fields {
+		switch f {
+		case "state":
+			parts = append(parts, "state "+snap.State)
+		case "assignees":
+			if len(snap.Assignees) == 0 {
+				parts = append(parts, "no assignees")
+			} else {
+				parts = append(parts, "assigned to "+strings.Join(snap.Assignees, ", "))
+			}
+		case "labels":
+			if len(snap.Labels) == 0 {
+				parts = append(parts, "no labels")
+			} else {
+				parts = append(parts, "labels "+strings.Join(snap.Labels, ", "))
+			}
+		case "milestone":
+			if snap.Milestone == 0 {
+				parts = append(parts, "no milestone")
+			} else {
+				parts = append(parts, fmt.Sprintf("milestone %d", snap.Milestone))
+			}
+		}
+	}
+	return fmt.Sprintf("#%d is back to %s", snap.Issue, strings.Join(parts, ", "))
+}
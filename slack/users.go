@@ -0,0 +1,345 @@
+package slack
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ctelfer-docker/slkiss/github"
+	"github.com/ctelfer-docker/slkiss/slackapi"
+)
+
+// How long slack user details are cached
+const userCacheTTL = time.Hour
+
+// Store bucket that registrations are kept in
+const usersBucket = "users"
+
+// How long a user has to post their registration code
+const registerTTL = time.Hour
+
+// Most gists whose files are searched for a registration code
+const maxGistChecks = 5
+
+// Matches an escaped user mention such as <@U123> or <@U123|bob>
+var mentionRe = regexp.MustCompile(`^<@([UW][A-Z0-9]+)(?:\|([^>]*))?>$`)
+
+// A slack user ID or github user name within a workspace
+type teamKey struct {
+	team string
+	key  string
+}
+
+// A github account registered for a slack user.  id is github's numeric
+// user ID, or 0 if it isn't known.
+type ghUser struct {
+	login string
+	id    int
+}
+
+// How a registration is kept in the store's users bucket under the key
+// "TEAM/USERID"
+type userRecord struct {
+	Team     string `json:"team"`
+	User     string `json:"user"`
+	Login    string `json:"login"`
+	GithubID int    `json:"github_id,omitempty"`
+}
+
+// A registration waiting for the user to show that they own the github
+// account
+type pendingUser struct {
+	ghUser
+	code    string
+	created time.Time
+}
+
+// Slack user details from users.info
+type cachedUser struct {
+	user    *slackapi.User
+	fetched time.Time
+}
+
+// Return the key of the slack user who sent a request.
+func requester(r *http.Request) teamKey {
+	return teamKey{r.PostForm.Get("team_id"), r.PostForm.Get("user_id")}
+}
+
+// Returned by AddUser() if the slack or github user is already registered
+var errRegistered = fmt.Errorf("Registration conflict")
+
+// Register the slack user with ID id in workspace team as github user
+// gname whose numeric ID is ghID.  Returns errRegistered if either is
+// already registered.  Nothing is registered if the registration can't be
+// saved.
+func (b *IssueBot) AddUser(team string, id string, gname string, ghID int) error {
+	if _, ok := b.s2g[teamKey{team, id}]; ok {
+		return errRegistered
+	}
+	if _, ok := b.g2s[teamKey{team, gname}]; ok {
+		return errRegistered
+	}
+	rec := &userRecord{Team: team, User: id, Login: gname, GithubID: ghID}
+	if err := b.store.Put(usersBucket, team+"/"+id, rec); err != nil {
+		log.WithField("method", "AddUser").Error("Unable to save registration of ", id, ": ", err)
+		return fmt.Errorf("Unable to save the registration")
+	}
+	b.s2g[teamKey{team, id}] = ghUser{gname, ghID}
+	b.g2s[teamKey{team, gname}] = id
+	return nil
+}
+
+// Delete the registration of the slack user with ID id in workspace team.
+func (b *IssueBot) DelUser(team string, id string) {
+	if gu, ok := b.s2g[teamKey{team, id}]; ok {
+		delete(b.g2s, teamKey{team, gu.login})
+		delete(b.s2g, teamKey{team, id})
+		if err := b.store.Delete(usersBucket, team+"/"+id); err != nil {
+			log.WithField("method", "DelUser").Error("Unable to delete registration of ", id, ": ", err)
+		}
+	}
+	delete(b.pending, teamKey{team, id})
+}
+
+// Load the registrations kept in the store.  Must be called with the lock
+// held.
+func (b *IssueBot) loadUsers() error {
+	keys, err := b.store.Keys(usersBucket)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		var rec userRecord
+		if _, err = b.store.Get(usersBucket, k, &rec); err != nil {
+			return err
+		}
+		b.s2g[teamKey{rec.Team, rec.User}] = ghUser{rec.Login, rec.GithubID}
+		b.g2s[teamKey{rec.Team, rec.Login}] = rec.User
+	}
+	log.WithField("method", "loadUsers").Infof("Loaded %d registered users", len(keys))
+	return nil
+}
+
+// Return slack's details for a user.  Details are cached for a while.
+func (b *IssueBot) slackUser(team string, id string) (*slackapi.User, error) {
+	k := teamKey{team, id}
+	b.Lock()
+	cu, ok := b.users[k]
+	api := b.teamAPI(team)
+	b.Unlock()
+	if ok && time.Since(cu.fetched) < userCacheTTL {
+		return cu.user, nil
+	}
+	if api.Token() == "" {
+		return nil, errNoBotToken
+	}
+	u, err := api.UserInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	b.Lock()
+	b.users[k] = &cachedUser{user: u, fetched: time.Now()}
+	b.Unlock()
+	return u, nil
+}
+
+// Return the name to show for a slack user.  Falls back to the user's ID
+// if slack can't be asked.
+func (b *IssueBot) slackName(team string, id string) string {
+	u, err := b.slackUser(team, id)
+	if err != nil {
+		log.WithField("method", "slackName").Info("Unable to look up user ", id, ": ", err)
+		return id
+	}
+	return u.DisplayName()
+}
+
+// Find the github name registered for a slack user given by name rather
+// than ID.  Also returns the name to display for the user.
+func (b *IssueBot) resolveSlackName(team string, sname string) (string, string, error) {
+	b.Lock()
+	gname, ok := b.byName[sname]
+	var ids []string
+	for k := range b.s2g {
+		if k.team == team {
+			ids = append(ids, k.key)
+		}
+	}
+	b.Unlock()
+	if ok {
+		return gname, "@" + sname, nil
+	}
+
+	for _, id := range ids {
+		u, err := b.slackUser(team, id)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(u.Name, sname) || strings.EqualFold(u.Profile.DisplayName, sname) {
+			b.Lock()
+			gu, ok := b.s2g[teamKey{team, id}]
+			b.Unlock()
+			if ok {
+				return gu.login, "@" + u.DisplayName(), nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("%q is not registered", "@"+sname)
+}
+
+// Return the registered users of the workspace that sent a request as
+// menu options.  Each option's value is a user argument for
+// resolveUser().
+func (b *IssueBot) userOptions(r *http.Request) []*option {
+	team := r.PostForm.Get("team_id")
+	b.Lock()
+	var ids []string
+	for k := range b.s2g {
+		if k.team == team {
+			ids = append(ids, k.key)
+		}
+	}
+	var snames []string
+	for sname := range b.byName {
+		snames = append(snames, sname)
+	}
+	b.Unlock()
+
+	var opts []*option
+	for _, id := range ids {
+		opts = append(opts, newOption("@"+b.slackName(team, id), "<@"+id+">"))
+	}
+	for _, sname := range snames {
+		opts = append(opts, newOption("@"+sname, "@"+sname))
+	}
+	sort.Slice(opts, func(i, j int) bool {
+		return strings.ToLower(opts[i].Text.Text) < strings.ToLower(opts[j].Text.Text)
+	})
+	return opts
+}
+
+// Start registering the slack user who sent a request as github user
+// login.  Returns the code the user must post to github to show that
+// they own the account.
+func (b *IssueBot) startRegistration(r *http.Request, login string) (string, error) {
+	k := requester(r)
+	b.Lock()
+	gu, ok := b.s2g[k]
+	_, taken := b.g2s[teamKey{k.team, login}]
+	agent := b.agent
+	b.Unlock()
+	if ok {
+		return "", fmt.Errorf("You are already registered as github user %q.  Use '/issue unregister' first.", gu.login)
+	}
+	if taken {
+		return "", fmt.Errorf("Registration conflict")
+	}
+
+	u, err := agent.GetUser(login)
+	if err != nil {
+		log.WithField("method", "startRegistration").Info("Unable to look up github user ", login, ": ", err)
+		return "", fmt.Errorf("Unable to find github user %q", login)
+	}
+
+	p := &pendingUser{
+		ghUser:  ghUser{u.Login, u.ID},
+		code:    "slkiss-" + newToken() + newToken(),
+		created: time.Now(),
+	}
+	b.Lock()
+	for pk, op := range b.pending {
+		if time.Since(op.created) > registerTTL {
+			delete(b.pending, pk)
+		}
+	}
+	b.pending[k] = p
+	b.Unlock()
+	return p.code, nil
+}
+
+// Finish the registration started by the slack user who sent a request
+// if the registration code appears in the github account's profile bio
+// or in one of its recent public gists.  Returns the registered login.
+func (b *IssueBot) finishRegistration(r *http.Request) (string, error) {
+	log := log.WithField("method", "finishRegistration")
+	k := requester(r)
+	b.Lock()
+	p, ok := b.pending[k]
+	agent := b.agent
+	b.Unlock()
+	if !ok || time.Since(p.created) > registerTTL {
+		return "", fmt.Errorf("You have no registration to finish.  Start one with '/issue register GITHUBUSER'.")
+	}
+
+	found, err := findCode(agent, p)
+	if err != nil {
+		log.Info("Unable to check github user ", p.login, ": ", err)
+		return "", fmt.Errorf("Unable to check github user %q.  Try again shortly.", p.login)
+	}
+	if !found {
+		return "", fmt.Errorf("Couldn't find %s in the bio or recent public gists of github user %q", p.code, p.login)
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	if b.pending[k] != p {
+		return "", fmt.Errorf("Your registration changed while it was being checked.  Try again.")
+	}
+	if err := b.AddUser(k.team, k.key, p.login, p.id); err != nil {
+		return "", err
+	}
+	delete(b.pending, k)
+	for sname, gname := range b.byName {
+		if gname == p.login {
+			delete(b.byName, sname)
+			log.Infof("Moved registration of @%s to user %s in %s", sname, k.key, k.team)
+		}
+	}
+	log.Infof("Registered user %s in %s as github user %s (%d)", k.key, k.team, p.login, p.id)
+	return p.login, nil
+}
+
+// Look for a registration code in a github user's profile bio and recent
+// public gists.  Fails if the login no longer belongs to the same account.
+func findCode(agent *github.Agent, p *pendingUser) (bool, error) {
+	u, err := agent.GetUser(p.login)
+	if err != nil {
+		return false, err
+	}
+	if u.ID != p.id {
+		return false, fmt.Errorf("github user %s changed ID from %d to %d", p.login, p.id, u.ID)
+	}
+	if strings.Contains(u.Bio, p.code) {
+		return true, nil
+	}
+
+	gists, err := agent.FetchGists(p.login)
+	if err != nil {
+		return false, err
+	}
+	for i, g := range gists {
+		if g.Owner != nil && g.Owner.ID != p.id {
+			continue
+		}
+		if strings.Contains(g.Description, p.code) {
+			return true, nil
+		}
+		// listings leave out the content so fetch the newest few
+		if i >= maxGistChecks {
+			continue
+		}
+		full, err := agent.GetGist(g.ID)
+		if err != nil {
+			return false, err
+		}
+		for _, f := range full.Files {
+			if strings.Contains(f.Content, p.code) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,119 @@
+package slack
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Slack request signing.  See:
+//   https://api.slack.com/authentication/verifying-requests-from-slack
+const (
+	sigHeader     = "X-Slack-Signature"
+	sigTimeHeader = "X-Slack-Request-Timestamp"
+	sigVersion    = "v0"
+
+	// Reject signed requests older than this to prevent replays
+	maxRequestAge = 5 * time.Minute
+
+	// Largest request body that will be read for verification
+	maxRequestBody = 1 << 20
+)
+
+// Checks that requests really came from slack.  If a signing secret is
+// set requests must carry a valid signature.  Otherwise if a (legacy)
+// verification token is set requests must carry that token.  If neither
+// is set every request is accepted.
+type verifier struct {
+	secret []byte
+	token  string
+	now    func() time.Time
+}
+
+// Require slack requests to be signed with this signing secret.  The
+// secret is on the "Basic Information" page of the slack app settings.
+func (b *IssueBot) SetSigningSecret(secret string) {
+	b.verifier.secret = []byte(secret)
+}
+
+// Require slack requests to carry this verification token.  Slack has
+// deprecated verification tokens in favor of signing secrets so this is
+// only checked if no signing secret is set.
+func (b *IssueBot) SetVerificationToken(token string) {
+	b.verifier.token = token
+}
+
+// Returns true if requests are checked in some way.
+func (v *verifier) enabled() bool {
+	return len(v.secret) > 0 || v.token != ""
+}
+
+// Check the signature on a request.  The request body is read and then
+// replaced so that it can be parsed again later.  If only a verification
+// token is set this does nothing:  callers must call checkToken() with
+// the token from the parsed request.  Requests that came over Socket Mode
+// aren't signed and are always accepted.
+func (v *verifier) verifySignature(r *http.Request) error {
+	if len(v.secret) == 0 || fromSocket(r) {
+		return nil
+	}
+
+	ts := r.Header.Get(sigTimeHeader)
+	sig := r.Header.Get(sigHeader)
+	if ts == "" || sig == "" {
+		return fmt.Errorf("request is not signed")
+	}
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed request timestamp %q", ts)
+	}
+	age := v.now().Sub(time.Unix(secs, 0))
+	if age > maxRequestAge || age < -maxRequestAge {
+		return fmt.Errorf("request timestamp is %s old", age)
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxRequestBody))
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if !hmac.Equal([]byte(sig), []byte(v.sign(ts, body))) {
+		return fmt.Errorf("request signature mismatch")
+	}
+	return nil
+}
+
+// Compute the signature slack would send for a request.
+func (v *verifier) sign(ts string, body []byte) string {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(sigVersion + ":" + ts + ":"))
+	mac.Write(body)
+	return sigVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Check the legacy verification token from a request.  Does nothing if
+// a signing secret is set since the signature has already been checked.
+func (v *verifier) checkToken(token string) error {
+	if len(v.secret) > 0 || v.token == "" {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(v.token)) != 1 {
+		return fmt.Errorf("verification token mismatch")
+	}
+	return nil
+}
+
+// Reject a request that failed verification.
+func verifyErr(w http.ResponseWriter, r *http.Request, err error) {
+	log.WithField("method", "verify").Warnf("Rejected request from %s: %s", r.RemoteAddr, err)
+	http.Error(w, "request verification failed", http.StatusUnauthorized)
+}
@@ -0,0 +1,221 @@
+package slackapi
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// A message to post or update.  Blocks and Attachments are marshalled to
+// JSON as they are.
+type Message struct {
+	Channel     string
+	Text        string
+	Blocks      interface{}
+	Attachments interface{}
+	ThreadTS    string // reply in this thread
+	TS          string // the message to update (for Update())
+}
+
+// A message that slack accepted
+type PostedMessage struct {
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+// A modal view that slack is showing
+type OpenedView struct {
+	ID   string `json:"id"`
+	Hash string `json:"hash"`
+}
+
+// A slack user
+type User struct {
+	ID      string `json:"id"`
+	TeamID  string `json:"team_id"`
+	Name    string `json:"name"`
+	Deleted bool   `json:"deleted"`
+	IsBot   bool   `json:"is_bot"`
+	IsAdmin bool   `json:"is_admin"`
+	Profile struct {
+		DisplayName string `json:"display_name"`
+		RealName    string `json:"real_name"`
+		Email       string `json:"email"`
+	} `json:"profile"`
+}
+
+// Return the name slack shows for a user.
+func (u *User) DisplayName() string {
+	switch {
+	case u.Profile.DisplayName != "":
+		return u.Profile.DisplayName
+	case u.Profile.RealName != "":
+		return u.Profile.RealName
+	}
+	return u.Name
+}
+
+// Return the API parameters for sending a message.
+func (m *Message) params() (url.Values, error) {
+	params := url.Values{"channel": {m.Channel}, "text": {m.Text}}
+	for name, v := range map[string]interface{}{"blocks": m.Blocks, "attachments": m.Attachments} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		// nil and empty slices are left out
+		if s := string(data); s != "null" && s != "[]" {
+			params.Set(name, s)
+		}
+	}
+	if m.ThreadTS != "" {
+		params.Set("thread_ts", m.ThreadTS)
+	}
+	return params, nil
+}
+
+// Post a message.  The channel may be a user ID to message them directly.
+func (c *Client) PostMessage(m *Message) (*PostedMessage, error) {
+	params, err := m.params()
+	if err != nil {
+		return nil, err
+	}
+	var out PostedMessage
+	err = c.Call("chat.postMessage", m.Channel, params, &out)
+	return &out, err
+}
+
+// Replace the text, blocks and attachments of a message the bot posted.
+func (c *Client) Update(m *Message) (*PostedMessage, error) {
+	params, err := m.params()
+	if err != nil {
+		return nil, err
+	}
+	params.Del("thread_ts")
+	params.Set("ts", m.TS)
+	var out PostedMessage
+	err = c.Call("chat.update", "", params, &out)
+	return &out, err
+}
+
+// Post a message to a channel that only one user can see.  Returns the
+// message's timestamp.
+func (c *Client) PostEphemeral(user string, m *Message) (string, error) {
+	params, err := m.params()
+	if err != nil {
+		return "", err
+	}
+	params.Set("user", user)
+	var out struct {
+		MessageTS string `json:"message_ts"`
+	}
+	err = c.Call("chat.postEphemeral", "", params, &out)
+	return out.MessageTS, err
+}
+
+// Unfurl links in a message.  unfurls maps each URL to the attachment to
+// show for it.
+func (c *Client) Unfurl(channel string, ts string, unfurls interface{}) error {
+	data, err := json.Marshal(unfurls)
+	if err != nil {
+		return err
+	}
+	params := url.Values{"channel": {channel}, "ts": {ts}, "unfurls": {string(data)}}
+	return c.Call("chat.unfurl", "", params, nil)
+}
+
+// Return a permanent link to a message.
+func (c *Client) Permalink(channel string, ts string) (string, error) {
+	var out struct {
+		Permalink string `json:"permalink"`
+	}
+	params := url.Values{"channel": {channel}, "message_ts": {ts}}
+	err := c.Call("chat.getPermalink", "", params, &out)
+	return out.Permalink, err
+}
+
+// Look up a user by ID.
+func (c *Client) UserInfo(user string) (*User, error) {
+	var out struct {
+		User *User `json:"user"`
+	}
+	if err := c.Call("users.info", "", url.Values{"user": {user}}, &out); err != nil {
+		return nil, err
+	}
+	return out.User, nil
+}
+
+// Look up a user by email address.
+func (c *Client) LookupUserByEmail(email string) (*User, error) {
+	var out struct {
+		User *User `json:"user"`
+	}
+	if err := c.Call("users.lookupByEmail", "", url.Values{"email": {email}}, &out); err != nil {
+		return nil, err
+	}
+	return out.User, nil
+}
+
+// Return the IDs of the members of a user group.
+func (c *Client) UserGroupMembers(group string) ([]string, error) {
+	var out struct {
+		Users []string `json:"users"`
+	}
+	err := c.Call("usergroups.users.list", "", url.Values{"usergroup": {group}}, &out)
+	return out.Users, err
+}
+
+// Open (or find) a direct message or group conversation with users and
+// return its channel ID.
+func (c *Client) OpenConversation(users ...string) (string, error) {
+	var out struct {
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+	}
+	params := url.Values{"users": {strings.Join(users, ",")}}
+	err := c.Call("conversations.open", "", params, &out)
+	return out.Channel.ID, err
+}
+
+// Open a modal view in response to an interaction.
+func (c *Client) OpenView(triggerID string, view interface{}) (*OpenedView, error) {
+	data, err := json.Marshal(view)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		View OpenedView `json:"view"`
+	}
+	params := url.Values{"trigger_id": {triggerID}, "view": {string(data)}}
+	err = c.Call("views.open", "", params, &out)
+	return &out.View, err
+}
+
+// Replace the contents of an open view.  If hash is set the update fails
+// when the view has changed since the hash was returned.
+func (c *Client) UpdateView(viewID string, hash string, view interface{}) (*OpenedView, error) {
+	data, err := json.Marshal(view)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		View OpenedView `json:"view"`
+	}
+	params := url.Values{"view_id": {viewID}, "view": {string(data)}}
+	if hash != "" {
+		params.Set("hash", hash)
+	}
+	err = c.Call("views.update", "", params, &out)
+	return &out.View, err
+}
+
+// Return a websocket URL for a Socket Mode connection.  The client's
+// token must be an app-level token.
+func (c *Client) OpenConnection() (string, error) {
+	var out struct {
+		URL string `json:"url"`
+	}
+	err := c.Call("apps.connections.open", "", url.Values{}, &out)
+	return out.URL, err
+}
@@ -0,0 +1,307 @@
+// A client for the parts of the slack Web API that the issuebot uses.
+// Calls wait as needed to stay within slack's per-method rate limits and
+// are retried when slack answers 429 Too Many Requests.  Failures are
+// returned as *Error, *RateLimitError or *StatusError.
+//
+// Typical use:
+//
+//	c := slackapi.New("xoxb-...")
+//	msg, err := c.PostMessage(&slackapi.Message{Channel: "C123", Text: "hi"})
+//
+// Tests can point a client at a fake with NewURL().
+package slackapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var l = logrus.WithFields(logrus.Fields{"component": "slackapi"})
+
+// Root URL of the slack Web API
+const APIRoot = "https://slack.com/api/"
+
+// The HTTP client used for all slack requests
+var HTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// Number of times a rate limited call is retried
+var MaxRetries = 3
+
+// Longest a call will wait for a rate limit to pass before giving up
+var MaxWait = time.Minute
+
+// An error that slack reported in a response with "ok" set to false
+type Error struct {
+	Method   string
+	Code     string   // e.g. "channel_not_found"
+	Needed   string   // scopes missing for "missing_scope"
+	Messages []string // details from response_metadata
+}
+
+func (e *Error) Error() string {
+	s := e.Method + ": " + e.Code
+	if e.Needed != "" {
+		s += " (needs " + e.Needed + ")"
+	}
+	if len(e.Messages) > 0 {
+		s += ": " + strings.Join(e.Messages, "; ")
+	}
+	return s
+}
+
+// Returned when slack is still rate limiting a call after the retries
+type RateLimitError struct {
+	Method     string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: rate limited (retry after %s)", e.Method, e.RetryAfter)
+}
+
+// Returned when slack answers with an unexpected HTTP status
+type StatusError struct {
+	Method string
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return e.Method + ": " + e.Status
+}
+
+// Returns true if err is an *Error with the given code.
+func IsError(err error, code string) bool {
+	e, ok := err.(*Error)
+	return ok && e.Code == code
+}
+
+// The fields common to every Web API response
+type apiResponse struct {
+	OK       bool   `json:"ok"`
+	Error    string `json:"error"`
+	Needed   string `json:"needed"`
+	Metadata struct {
+		Messages []string `json:"messages"`
+	} `json:"response_metadata"`
+}
+
+// A Web API client.  Clients made from one another with WithToken() share
+// their rate limits.
+type Client struct {
+	api    string
+	token  string
+	limits *limiter
+}
+
+// Create a client for the public slack API that calls with token.
+func New(token string) *Client {
+	return NewURL(APIRoot, token)
+}
+
+// Create a client for the slack API rooted at api.
+func NewURL(api string, token string) *Client {
+	if !strings.HasSuffix(api, "/") {
+		api += "/"
+	}
+	return &Client{api: api, token: token, limits: newLimiter()}
+}
+
+// Return a client for the same API that calls with another token.
+func (c *Client) WithToken(token string) *Client {
+	return &Client{api: c.api, token: token, limits: c.limits}
+}
+
+// Return the root URL of the API.
+func (c *Client) URL() string {
+	return c.api
+}
+
+// Return the token sent with calls.
+func (c *Client) Token() string {
+	return c.token
+}
+
+// Call a Web API method with form encoded parameters and decode the
+// response into out (which may be nil).  The call waits for the method's
+// rate limit.  key narrows the limit for methods that slack limits per
+// channel and is otherwise empty.
+func (c *Client) Call(method string, key string, params url.Values, out interface{}) error {
+	lk := limitKey{token: c.token, method: method, key: key}
+	for i := 0; ; i++ {
+		if err := c.limits.wait(lk, MaxWait); err != nil {
+			return err
+		}
+		retryAfter, err := c.call(method, params, out)
+		if retryAfter == 0 {
+			return err
+		}
+		c.limits.pause(lk, retryAfter)
+		if i == MaxRetries || retryAfter > MaxWait {
+			return err
+		}
+		l.WithField("method", method).Info("Rate limited by slack:  retrying in ", retryAfter)
+	}
+}
+
+// Make one call.  Returns how long to wait if slack rate limited it.
+func (c *Client) call(method string, params url.Values, out interface{}) (time.Duration, error) {
+	req, err := http.NewRequest("POST", c.api+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil || secs < 1 {
+			secs = 1
+		}
+		d := time.Duration(secs) * time.Second
+		return d, &RateLimitError{Method: method, RetryAfter: d}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, &StatusError{Method: method, Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	var raw json.RawMessage
+	if err = json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return 0, fmt.Errorf("%s: %s", method, err)
+	}
+	var ar apiResponse
+	if err = json.Unmarshal(raw, &ar); err != nil {
+		return 0, fmt.Errorf("%s: %s", method, err)
+	}
+	if !ar.OK {
+		return 0, &Error{Method: method, Code: ar.Error, Needed: ar.Needed, Messages: ar.Metadata.Messages}
+	}
+	if out == nil {
+		return 0, nil
+	}
+	return 0, json.Unmarshal(raw, out)
+}
+
+// Slack's rate limit tiers in calls per minute.  See:
+//   https://api.slack.com/docs/rate-limits
+const (
+	tier1 = 1
+	tier2 = 20
+	tier3 = 50
+	tier4 = 100
+)
+
+// Calls per minute allowed for each method.  chat.postMessage is limited
+// to about one message per second in each channel.  Methods not listed
+// get tier 3.
+var methodLimits = map[string]int{
+	"apps.connections.open": tier1,
+	"chat.postMessage":      60,
+	"chat.update":           tier3,
+	"chat.postEphemeral":    tier4,
+	"chat.unfurl":           tier3,
+	"chat.getPermalink":     tier4,
+	"conversations.open":    tier3,
+	"oauth.v2.access":       tier4,
+	"usergroups.users.list": tier2,
+	"users.info":            tier4,
+	"users.lookupByEmail":   tier3,
+	"views.open":            tier4,
+	"views.update":          tier4,
+}
+
+// Calls are limited per token (i.e. per workspace) and method
+type limitKey struct {
+	token  string
+	method string
+	key    string
+}
+
+// Slack tolerates short bursts so every bucket holds at least this many
+// calls even for methods limited to fewer per minute
+const minBurst = 5
+
+// A token bucket for one method.  The bucket holds up to a minute's worth
+// of calls so that short bursts go through straight away.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	until  time.Time // slack asked us to wait until then
+}
+
+type limiter struct {
+	sync.Mutex
+	buckets map[limitKey]*bucket
+}
+
+func newLimiter() *limiter {
+	return &limiter{buckets: make(map[limitKey]*bucket)}
+}
+
+// Wait until a call may be made.  Fails rather than wait longer than max.
+func (lm *limiter) wait(k limitKey, max time.Duration) error {
+	rate, ok := methodLimits[k.method]
+	if !ok {
+		rate = tier3
+	}
+	burst := float64(rate)
+	if burst < minBurst {
+		burst = minBurst
+	}
+	lm.Lock()
+	now := time.Now()
+	b, ok := lm.buckets[k]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		lm.buckets[k] = b
+	}
+	b.tokens += now.Sub(b.last).Minutes() * float64(rate)
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+
+	var d time.Duration
+	if b.tokens < 1 {
+		d = time.Duration((1 - b.tokens) / float64(rate) * float64(time.Minute))
+	}
+	if b.until.Sub(now) > d {
+		d = b.until.Sub(now)
+	}
+	if d > max {
+		lm.Unlock()
+		return &RateLimitError{Method: k.method, RetryAfter: d}
+	}
+	// take the token now so that waiting callers queue up behind us
+	b.tokens--
+	lm.Unlock()
+
+	if d > 0 {
+		time.Sleep(d)
+	}
+	return nil
+}
+
+// Hold off calls after slack said to retry after d.
+func (lm *limiter) pause(k limitKey, d time.Duration) {
+	lm.Lock()
+	defer lm.Unlock()
+	if b, ok := lm.buckets[k]; ok {
+		b.until = time.Now().Add(d)
+	}
+}
@@ -0,0 +1,230 @@
+// Keeps the issuebot's state (such as registered users) across restarts.
+// State is kept as JSON values in named buckets so that each kind of state
+// has a bucket of its own and new kinds can be added without touching
+// this package.
+//
+// Typical use:
+//
+//	st, err := store.Open("/var/lib/issuebot/state.json")
+//	err = st.Put("users", "T123/U456", &rec)
+//	ok, err := st.Get("users", "T123/U456", &rec)
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.WithFields(logrus.Fields{"component": "store"})
+
+// Store is durable storage for JSON encoded values grouped into buckets.
+// A bucket that has never been written to is empty.
+type Store interface {
+	// Decode the value of key in bucket into v.  Returns false if there
+	// is no such key.
+	Get(bucket string, key string, v interface{}) (bool, error)
+
+	// Set the value of key in bucket to v encoded as JSON.
+	Put(bucket string, key string, v interface{}) error
+
+	// Remove key from bucket.  Removing a missing key is not an error.
+	Delete(bucket string, key string) error
+
+	// Return the keys in bucket in sorted order.
+	Keys(bucket string) ([]string, error)
+}
+
+// Version of the file format that this package writes
+const Version = 1
+
+// The on-disk format of a File
+type contents struct {
+	Version int                                   `json:"version"`
+	Buckets map[string]map[string]json.RawMessage `json:"buckets"`
+}
+
+// A migration upgrades the contents of a file by one version
+type migration func(c *contents) error
+
+// migrations[n] upgrades a file from version n+1 to version n+2.  When the
+// format changes, bump Version and add the migration from the old version
+// here so that existing files are upgraded when they are opened.
+var migrations = []migration{}
+
+// File is a Store that keeps all of its data in memory and rewrites a
+// single JSON file on every change.  The file is replaced atomically so a
+// crash leaves either the old or the new state but never a partial file.
+// It is meant for the small amount of state the bot keeps.
+type File struct {
+	sync.Mutex
+	path    string
+	buckets map[string]map[string]json.RawMessage
+}
+
+// Open the store kept in the file at path, creating it on the first
+// write if it doesn't exist.  If path is empty the store is only kept in
+// memory.
+func Open(path string) (*File, error) {
+	f := &File{path: path, buckets: make(map[string]map[string]json.RawMessage)}
+	if path == "" {
+		return f, nil
+	}
+	if err := f.load(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Implement Store.
+func (f *File) Get(bucket string, key string, v interface{}) (bool, error) {
+	f.Lock()
+	data, ok := f.buckets[bucket][key]
+	f.Unlock()
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("%s/%s: %s", bucket, key, err)
+	}
+	return true, nil
+}
+
+// Implement Store.
+func (f *File) Put(bucket string, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f.Lock()
+	defer f.Unlock()
+	b, ok := f.buckets[bucket]
+	if !ok {
+		b = make(map[string]json.RawMessage)
+		f.buckets[bucket] = b
+	}
+	old, existed := b[key]
+	b[key] = data
+	if err = f.save(); err != nil {
+		if existed {
+			b[key] = old
+		} else {
+			delete(b, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// Implement Store.
+func (f *File) Delete(bucket string, key string) error {
+	f.Lock()
+	defer f.Unlock()
+	old, ok := f.buckets[bucket][key]
+	if !ok {
+		return nil
+	}
+	delete(f.buckets[bucket], key)
+	if err := f.save(); err != nil {
+		f.buckets[bucket][key] = old
+		return err
+	}
+	return nil
+}
+
+// Implement Store.
+func (f *File) Keys(bucket string) ([]string, error) {
+	f.Lock()
+	defer f.Unlock()
+	var keys []string
+	for k := range f.buckets[bucket] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Load the store from its file upgrading older formats.
+func (f *File) load() error {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	var c contents
+	if err = json.Unmarshal(data, &c); err != nil {
+		return fmt.Errorf("%s: %s", f.path, err)
+	}
+	if c.Version < 1 || c.Version > Version {
+		return fmt.Errorf("%s: unsupported version %d (expected at most %d)", f.path, c.Version, Version)
+	}
+	for v := c.Version; v < Version; v++ {
+		if err = migrations[v-1](&c); err != nil {
+			return fmt.Errorf("%s: upgrading from version %d: %s", f.path, v, err)
+		}
+		log.Infof("upgraded %s from version %d to %d", f.path, v, v+1)
+	}
+	if c.Buckets != nil {
+		f.buckets = c.Buckets
+	}
+	if c.Version < Version {
+		return f.save()
+	}
+	return nil
+}
+
+// Save the store to its file.  The file is written to a temporary file
+// first and then renamed.  The state may include secrets so the file is
+// only readable by the owner.  Must be called with the lock held.
+func (f *File) save() error {
+	if f.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(&contents{Version: Version, Buckets: f.buckets}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), ".store")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	// make sure the data is on disk before the rename makes it visible
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), f.path); err != nil {
+		return err
+	}
+	// the rename itself is only durable once the directory is synced
+	return syncDir(filepath.Dir(f.path))
+}
+
+// Flush a directory's entries to disk.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -5,23 +5,71 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
 
 	"github.com/ctelfer-docker/slkiss/slack"
+	"github.com/ctelfer-docker/slkiss/store"
 )
 
 var addr  = flag.String("l", "", "Address to listen on")
 var port  = flag.Uint("p", 80, "Port to listen on")
 var cfgfn = flag.String("c", "", "Config field to load")
+var api   = flag.String("g", "", "Root URL of the github API")
+var stfn  = flag.String("d", "", "File to keep bot state in")
+var slk   = flag.String("S", "", "Root URL of the slack API")
+var apptk = flag.String("A", "", "Slack app-level token (to use Socket Mode)")
 
 func main() {
 	flag.Parse()
 	a := fmt.Sprintf("%s:%d", *addr, *port)
 	log.Println(a)
 	bot := slack.NewIssueBot(a, "ctelfer-docker/slkiss")
+	if *api != "" {
+		bot.SetGithubURL(*api)
+	}
+	if *slk != "" {
+		bot.SetSlackURL(*slk)
+	}
+	bot.SetAppToken(*apptk)
+	if *stfn != "" {
+		st, err := store.Open(*stfn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = bot.SetStore(st); err != nil {
+			log.Fatal(err)
+		}
+	}
 	bot.AddUserMap("ctelfer", "ctelfer-docker")
+	err := bot.RegisterCommand("echo", slack.CommandSpec{
+		Summary: "repeat some text back",
+		Usage:   "[-s|--shout] TEXT...",
+		Aliases: []string{"say"},
+		Role:    "reporter",
+	}, echo)
+	if err != nil {
+		log.Fatal(err)
+	}
 	if *cfgfn != "" {
 		log.Println("Loading config file")
+		cfg, err := slack.LoadConfig(*cfgfn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = bot.SetConfig(cfg); err != nil {
+			log.Fatal(err)
+		}
 	}
 	log.Println("Starting bot")
 	bot.Run()
 }
+
+// An example of a command added by the program embedding the bot
+func echo(b *slack.IssueBot, w http.ResponseWriter, r *http.Request, a *slack.Args) {
+	text := a.Get("TEXT")
+	if a.Has("shout") {
+		text = strings.ToUpper(text)
+	}
+	w.Write([]byte(text))
+}
@@ -0,0 +1,63 @@
+// Runs a fake github API server for trying out the other test programs
+// and the issuebot without touching a real repository.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/ctelfer-docker/slkiss/github"
+	"github.com/ctelfer-docker/slkiss/githubtest"
+)
+
+var repo  = flag.String("r", "ctelfer-docker/slkiss", "Repository to create")
+var count = flag.Int("n", 50, "Number of issues to create")
+var prs   = flag.Int("p", 0, "Number of open pull requests to create after the issues")
+var users = flag.String("u", "ctelfer-docker", "Comma separated list of assignable users")
+var token = flag.String("a", "", "Authorization header to require for modifications")
+
+func main() {
+	flag.Parse()
+
+	s := githubtest.NewServer()
+	defer s.Close()
+	for _, u := range strings.Split(*users, ",") {
+		if u != "" {
+			s.AddUser(u)
+		}
+	}
+	s.AddLabel(*repo, "bug", "d73a4a")
+	s.AddLabel(*repo, "enhancement", "a2eeef")
+	for i := 1; i <= *count; i++ {
+		iss := &github.Issue{
+			Title: fmt.Sprintf("Test issue %d", i),
+			Body:  fmt.Sprintf("This is the body of test issue %d", i),
+		}
+		if i%3 == 0 {
+			iss.Labels = []*github.Label{{Name: "bug"}}
+		}
+		if i%5 == 0 {
+			iss.State = "closed"
+		}
+		s.AddIssue(*repo, iss)
+	}
+	for i := 1; i <= *prs; i++ {
+		s.AddIssue(*repo, &github.Issue{
+			Title:       fmt.Sprintf("Test pull request %d", i),
+			Body:        fmt.Sprintf("This is the body of test pull request %d", i),
+			PullRequest: &github.PullRequestRef{},
+		})
+	}
+	if *token != "" {
+		s.RequireToken(*token)
+	}
+
+	log.Printf("Fake github API for %s listening at %s", *repo, s.URL)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+}
@@ -0,0 +1,232 @@
+// Runs a fake slack Socket Mode server for checking a bot's Socket Mode
+// support without a slack workspace.  Point the bot at it with an
+// app-level token, e.g.:
+//
+//	./fakeslack -p 8088 &
+//	./dumbbot -p 8089 -S http://127.0.0.1:8088/ -A xapp-test
+//
+// Each connection is sent a hello and then a set of envelopes whose
+// acknowledgements are checked.  The first connection is then told to
+// disconnect, which the bot must answer by connecting again.  Once the
+// last connection is checked the fake exits, with an error if anything
+// went wrong.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctelfer-docker/slkiss/websocket"
+)
+
+var addr   = flag.String("l", "127.0.0.1", "Address to listen on")
+var port   = flag.Uint("p", 8088, "Port to listen on")
+var token  = flag.String("t", "xapp-test", "App-level token to require")
+var rounds = flag.Int("n", 2, "Number of connections to check")
+var wait   = flag.Duration("w", 10*time.Second, "How long to wait for acknowledgements")
+
+// An envelope to send and what its acknowledgement must hold
+type envelope struct {
+	Type                   string      `json:"type"`
+	EnvelopeID             string      `json:"envelope_id"`
+	Payload                interface{} `json:"payload"`
+	AcceptsResponsePayload bool        `json:"accepts_response_payload"`
+
+	want string // text the acknowledgement's payload must contain
+}
+
+// What a bot acknowledges an envelope with
+type ack struct {
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+func envelopes(round int) []*envelope {
+	id := func(n int) string {
+		return fmt.Sprintf("env-%d-%d", round, n)
+	}
+	command := func(text string) map[string]string {
+		return map[string]string{
+			"command":    "/issue",
+			"text":       text,
+			"team_id":    "T0FAKE",
+			"user_id":    "U0FAKE",
+			"user_name":  "fake",
+			"channel_id": "C0FAKE",
+		}
+	}
+	return []*envelope{
+		{Type: "slash_commands", EnvelopeID: id(1), Payload: command("echo hello there"),
+			AcceptsResponsePayload: true, want: "hello there"},
+		{Type: "slash_commands", EnvelopeID: id(2), Payload: command("help"),
+			AcceptsResponsePayload: true, want: "usage"},
+		{Type: "events_api", EnvelopeID: id(3), Payload: map[string]interface{}{
+			"type":    "event_callback",
+			"team_id": "T0FAKE",
+			"event":   map[string]string{"type": "reaction_added", "user": "U0FAKE"},
+		}},
+		{Type: "interactive", EnvelopeID: id(4), Payload: map[string]interface{}{
+			"type":    "block_actions",
+			"user":    map[string]string{"id": "U0FAKE", "username": "fake"},
+			"team":    map[string]string{"id": "T0FAKE"},
+			"actions": []interface{}{},
+		}},
+	}
+}
+
+type fake struct {
+	sync.Mutex
+	url    string
+	opened int // apps.connections.open calls
+	round  int // websocket connections
+	failed []string
+	done   chan struct{}
+}
+
+func (f *fake) fail(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Print("FAIL ", msg)
+	f.Lock()
+	f.failed = append(f.failed, msg)
+	f.Unlock()
+}
+
+// POST /apps.connections.open
+func (f *fake) openConnection(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if r.Header.Get("Authorization") != "Bearer "+*token {
+		f.fail("apps.connections.open called without the app-level token")
+		w.Write([]byte(`{"ok":false,"error":"invalid_auth"}`))
+		return
+	}
+	f.Lock()
+	f.opened++
+	n := f.opened
+	f.Unlock()
+	wsurl := strings.Replace(f.url, "http://", "ws://", 1) + fmt.Sprintf("link/?ticket=%d", n)
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "url": wsurl})
+}
+
+// Any other web API method succeeds without doing anything.
+func (f *fake) otherMethod(w http.ResponseWriter, r *http.Request) {
+	log.Print("Web API call ", r.URL.Path)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write([]byte(`{"ok":true}`))
+}
+
+// GET /link/ upgraded to a Socket Mode websocket
+func (f *fake) link(w http.ResponseWriter, r *http.Request) {
+	c, err := websocket.Upgrade(w, r)
+	if err != nil {
+		f.fail("websocket upgrade: %s", err)
+		return
+	}
+	f.Lock()
+	f.round++
+	round := f.round
+	f.Unlock()
+	log.Printf("Connection %d from %s", round, r.RemoteAddr)
+	defer c.Close()
+
+	if err = send(c, map[string]string{"type": "hello"}); err != nil {
+		f.fail("connection %d: sending hello: %s", round, err)
+		return
+	}
+	envs := envelopes(round)
+	pending := make(map[string]*envelope)
+	for _, env := range envs {
+		if err = send(c, env); err != nil {
+			f.fail("connection %d: sending %s: %s", round, env.EnvelopeID, err)
+			return
+		}
+		pending[env.EnvelopeID] = env
+	}
+	f.checkAcks(c, round, pending)
+
+	if round < *rounds {
+		send(c, map[string]interface{}{"type": "disconnect", "reason": "refresh_requested"})
+		c.SetReadDeadline(time.Now().Add(*wait))
+		for {
+			if _, _, err = c.ReadMessage(); err != nil {
+				break
+			}
+		}
+		if _, ok := err.(*websocket.CloseError); !ok {
+			f.fail("connection %d: not closed after disconnect: %s", round, err)
+		}
+		return
+	}
+	close(f.done)
+}
+
+// Read acknowledgements until every pending envelope has one.
+func (f *fake) checkAcks(c *websocket.Conn, round int, pending map[string]*envelope) {
+	c.SetReadDeadline(time.Now().Add(*wait))
+	for len(pending) > 0 {
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			for id := range pending {
+				f.fail("connection %d: %s was not acknowledged: %s", round, id, err)
+			}
+			return
+		}
+		var a ack
+		if err = json.Unmarshal(data, &a); err != nil {
+			f.fail("connection %d: malformed acknowledgement %q", round, data)
+			continue
+		}
+		env, ok := pending[a.EnvelopeID]
+		if !ok {
+			f.fail("connection %d: acknowledgement of unknown envelope %q", round, a.EnvelopeID)
+			continue
+		}
+		delete(pending, a.EnvelopeID)
+		switch {
+		case env.want != "" && !strings.Contains(string(a.Payload), env.want):
+			f.fail("connection %d: %s %s acknowledged with %s, expected %q", round,
+				env.Type, a.EnvelopeID, a.Payload, env.want)
+		default:
+			log.Printf("ok   connection %d: %s %s acknowledged", round, env.Type, a.EnvelopeID)
+		}
+	}
+}
+
+func send(c *websocket.Conn, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(websocket.TextMessage, data)
+}
+
+func main() {
+	flag.Parse()
+	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", *addr, *port))
+	if err != nil {
+		log.Fatal(err)
+	}
+	f := &fake{url: "http://" + l.Addr().String() + "/", done: make(chan struct{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps.connections.open", f.openConnection)
+	mux.HandleFunc("/link/", f.link)
+	mux.HandleFunc("/", f.otherMethod)
+	go http.Serve(l, mux)
+	log.Printf("Fake slack API listening at %s", f.url)
+
+	<-f.done
+	f.Lock()
+	defer f.Unlock()
+	if len(f.failed) > 0 {
+		fmt.Printf("%d checks failed\n", len(f.failed))
+		os.Exit(1)
+	}
+	fmt.Printf("All envelopes acknowledged over %d connections\n", f.round)
+}
@@ -9,10 +9,13 @@ import (
 	"text/template"
 
 	"github.com/ctelfer-docker/slkiss/github"
+	"github.com/ctelfer-docker/slkiss/replay"
 )
 
 var repo = flag.String("r", "ctelfer-docker/slkiss", "Default repository to search")
 var inum = flag.Int("n", -1, "Issue number to fetch")
+var api  = flag.String("g", github.APIRoot, "Root URL of the github API")
+var rec  = flag.String("R", "", "Record the github interactions to this fixture file")
 
 const issueTmpl = `Number:    {{.Number}}
 Title:     {{.Title}}
@@ -38,7 +41,12 @@ func addParam(pm map[string]string, p string) {
 func main() {
 	flag.Parse()
 
-	a := github.NewRepoAgent(*repo)
+	if *rec != "" {
+		recorder = replay.NewRecorder(nil)
+		github.Client.Transport = recorder
+	}
+
+	a := github.NewRepoAgentURL(*api, *repo)
 
 	if *inum < 0 {
 		a.AddParam("per_page", "100")
@@ -48,23 +56,42 @@ func main() {
 		}
 		issues, err := a.FetchIssues(pm)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 
 		fmt.Printf("There are %d issues in the query\n", len(issues))
 		if err := listRpt.Execute(os.Stdout, issues); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	} else {
 		if len(flag.Args()) > 0 {
-			log.Fatal("Extra query parameters illegal when fetching one issue")
+			fatal("Extra query parameters illegal when fetching one issue")
 		}
 		issue, err := a.GetIssue(*inum)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		if err = issueRpt.Execute(os.Stdout, issue); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	}
+	saveFixture()
+}
+
+var recorder *replay.Transport
+
+// Save the recorded github interactions if recording
+func saveFixture() {
+	if recorder == nil {
+		return
+	}
+	if err := recorder.Save(*rec); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Save any recorded interactions and exit with an error.
+func fatal(v ...interface{}) {
+	saveFixture()
+	log.Fatal(v...)
 }
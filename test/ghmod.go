@@ -7,12 +7,15 @@ import (
 	"os"
 
 	"github.com/ctelfer-docker/slkiss/github"
+	"github.com/ctelfer-docker/slkiss/replay"
 )
 
 var repo = flag.String("r", "ctelfer-docker/slkiss", "Default repository to search")
 var inum = flag.Int("i", -1, "Issue number to fetch")
 var user = flag.String("u", "ctelfer-docker", "User to operate as")
 var auth = flag.String("a", "", "Authentication token")
+var api  = flag.String("g", github.APIRoot, "Root URL of the github API")
+var rec  = flag.String("R", "", "Record the github interactions to this fixture file")
 
 const authEnv = "GHMOD_PASSWORD"
 
@@ -46,7 +49,13 @@ func main() {
 		*auth = s
 	}
 
-	a := github.NewRepoAgent(*repo)
+	var recorder *replay.Transport
+	if *rec != "" {
+		recorder = replay.NewRecorder(nil)
+		github.Client.Transport = recorder
+	}
+
+	a := github.NewRepoAgentURL(*api, *repo)
 	t := encodeBasicAuth(*user, *auth)
 	a.SetToken(t)
 
@@ -66,6 +75,11 @@ func main() {
 		usage()
 	}
 
+	if recorder != nil {
+		if serr := recorder.Save(*rec); serr != nil {
+			fmt.Fprintln(os.Stderr, serr.Error())
+		}
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
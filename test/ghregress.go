@@ -0,0 +1,151 @@
+// Regression checks for the github package run against recorded github
+// interactions in the fixtures directory.  Use the -R option of ghfetch
+// or ghmod to record new fixtures.
+//
+// The fixtures in fixtures/synthetic are synthetic:  they were recorded
+// from fakehub rather than from api.github.com, so they only show that the
+// github package agrees with the githubtest fake.  The checks depend on
+// fakehub's generated issues (e.g. "Test issue 3").
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ctelfer-docker/slkiss/github"
+	"github.com/ctelfer-docker/slkiss/replay"
+)
+
+var dir = flag.String("d", "fixtures/synthetic", "Directory containing the fixtures")
+
+// The fixtures were recorded using this repository's name.  Recordings
+// are matched by path and query so the API host doesn't matter.
+const base = github.APIURL + "ctelfer-docker/slkiss/issues"
+
+type check struct {
+	fixture string
+	desc    string
+	run     func() error
+}
+
+var checks = []check{
+	{"search.json", "SearchIssues follows pagination links", checkSearch},
+	{"listprs.json", "SearchIssues marks pull requests", checkListPRs},
+	{"getissue.json", "GetIssue decodes an issue", checkGetIssue},
+	{"getmissing.json", "GetIssue fails on a missing issue", checkGetMissing},
+	{"modissue.json", "ModIssue closes an issue", checkModIssue},
+	{"modmissing.json", "ModIssue fails on a missing issue", checkModMissing},
+}
+
+func main() {
+	flag.Parse()
+	failed := 0
+	for _, c := range checks {
+		err := runCheck(c)
+		if err != nil {
+			fmt.Printf("FAIL %s: %s: %s\n", c.fixture, c.desc, err)
+			failed++
+		} else {
+			fmt.Printf("ok   %s: %s\n", c.fixture, c.desc)
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks))
+		os.Exit(1)
+	}
+}
+
+func runCheck(c check) error {
+	t, err := replay.Load(filepath.Join(*dir, c.fixture))
+	if err != nil {
+		return err
+	}
+	github.Client.Transport = t
+	if err = c.run(); err != nil {
+		return err
+	}
+	if unused := t.Unused(); len(unused) > 0 {
+		return fmt.Errorf("%d recorded requests were not made (first: %s %s)",
+			len(unused), unused[0].Request.Method, unused[0].Request.URL)
+	}
+	return nil
+}
+
+func checkSearch() error {
+	params := map[string]string{"state": "all", "per_page": "8"}
+	issues, err := github.SearchIssues(base, params)
+	if err != nil {
+		return err
+	}
+	if len(issues) != 20 {
+		return fmt.Errorf("expected 20 issues got %d", len(issues))
+	}
+	seen := make(map[int]bool)
+	for _, iss := range issues {
+		if seen[iss.Number] {
+			return fmt.Errorf("issue %d returned twice", iss.Number)
+		}
+		seen[iss.Number] = true
+	}
+	return nil
+}
+
+func checkListPRs() error {
+	params := map[string]string{"state": "open", "per_page": "100"}
+	issues, err := github.SearchIssues(base, params)
+	if err != nil {
+		return err
+	}
+	prs := 0
+	for _, iss := range issues {
+		if iss.IsPullRequest() {
+			prs++
+			if !strings.HasPrefix(iss.Title, "Test pull request") {
+				return fmt.Errorf("issue %d is marked as a pull request", iss.Number)
+			}
+		}
+	}
+	if len(issues) != 6 || prs != 2 {
+		return fmt.Errorf("expected 6 issues and 2 pull requests got %d and %d", len(issues), prs)
+	}
+	return nil
+}
+
+func checkGetIssue() error {
+	iss, err := github.GetIssue(base, "", 3)
+	if err != nil {
+		return err
+	}
+	if iss.Number != 3 || iss.Title != "Test issue 3" || iss.State != "open" {
+		return fmt.Errorf("unexpected issue: %d %q %s", iss.Number, iss.Title, iss.State)
+	}
+	if len(iss.Labels) != 1 || iss.Labels[0].Name != "bug" {
+		return fmt.Errorf("expected the 'bug' label")
+	}
+	if iss.User == nil || iss.CreatedAt.IsZero() || iss.UpdatedAt.IsZero() {
+		return fmt.Errorf("user or timestamps not decoded")
+	}
+	return nil
+}
+
+func checkGetMissing() error {
+	if _, err := github.GetIssue(base, "", 999); err == nil {
+		return fmt.Errorf("expected an error")
+	}
+	return nil
+}
+
+func checkModIssue() error {
+	return github.ModIssue(base, "token", 3, map[string]interface{}{"state": "closed"})
+}
+
+func checkModMissing() error {
+	err := github.ModIssue(base, "token", 999, map[string]interface{}{"state": "closed"})
+	if err == nil {
+		return fmt.Errorf("expected an error")
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+// A small websocket (RFC 6455) implementation with just enough to talk to
+// slack's Socket Mode:  a client to dial out and a server side upgrade so
+// that tests can run a fake.  Messages are read and written whole.  Pings
+// are answered automatically.  Extensions and subprotocols are not
+// supported.
+//
+// Typical use:
+//
+//	c, err := websocket.Dial("wss://example.com/socket", nil)
+//	...
+//	op, msg, err := c.ReadMessage()
+//	err = c.WriteMessage(websocket.TextMessage, reply)
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message types (frame opcodes)
+const (
+	continuation  = 0
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// Close status codes
+const (
+	CloseNormal    = 1000
+	CloseGoingAway = 1001
+	CloseProtocol  = 1002
+	CloseNoStatus  = 1005
+	CloseTooBig    = 1009
+)
+
+// Largest message that will be read
+const MaxMessageSize = 16 << 20
+
+// Time allowed to connect and complete the opening handshake
+var HandshakeTimeout = 30 * time.Second
+
+// Appended to the client's key to compute the server's accept key
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Returned by ReadMessage() once the peer has closed the connection
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket closed (%d)", e.Code)
+	}
+	return fmt.Sprintf("websocket closed (%d): %s", e.Code, e.Reason)
+}
+
+// A websocket connection.  Reads must come from one goroutine at a time
+// but writes may come from any number of goroutines.
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool // client frames are masked
+
+	wmu    sync.Mutex
+	closed bool // a close frame has been sent
+}
+
+// Open a websocket connection to a ws:// or wss:// URL.  header holds any
+// extra headers to send with the opening request.
+func Dial(rawurl string, header http.Header) (*Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "ws":
+			host += ":80"
+		case "wss":
+			host += ":443"
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: HandshakeTimeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		conn, err = dialer.Dial("tcp", host)
+	case "wss":
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("websocket: unsupported URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+
+	c, err := handshake(conn, u, header)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// Send the opening request and check the server's reply.
+func handshake(conn net.Conn, u *url.URL, header http.Header) (*Conn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	bw := bufio.NewWriter(conn)
+	fmt.Fprintf(bw, "GET %s HTTP/1.1\r\n", u.RequestURI())
+	fmt.Fprintf(bw, "Host: %s\r\n", u.Host)
+	fmt.Fprintf(bw, "Upgrade: websocket\r\n")
+	fmt.Fprintf(bw, "Connection: Upgrade\r\n")
+	fmt.Fprintf(bw, "Sec-WebSocket-Key: %s\r\n", key)
+	fmt.Fprintf(bw, "Sec-WebSocket-Version: 13\r\n")
+	for k, vs := range header {
+		for _, v := range vs {
+			fmt.Fprintf(bw, "%s: %s\r\n", k, v)
+		}
+	}
+	bw.WriteString("\r\n")
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("websocket: handshake failed: %s", resp.Status)
+	}
+	if !headerHas(resp.Header, "Upgrade", "websocket") || !headerHas(resp.Header, "Connection", "upgrade") {
+		return nil, fmt.Errorf("websocket: server did not upgrade the connection")
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, fmt.Errorf("websocket: bad Sec-WebSocket-Accept from server")
+	}
+	return &Conn{conn: conn, br: br, client: true}, nil
+}
+
+// Accept a websocket connection on the server side.  On failure an error
+// has already been sent to the client.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != "GET" || key == "" ||
+		!headerHas(r.Header, "Upgrade", "websocket") ||
+		!headerHas(r.Header, "Connection", "upgrade") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "websocket upgrade expected", http.StatusBadRequest)
+		return nil, fmt.Errorf("websocket: not a websocket request")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("websocket: connection can't be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n")
+	fmt.Fprintf(rw, "Upgrade: websocket\r\nConnection: Upgrade\r\n")
+	fmt.Fprintf(rw, "Sec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, br: rw.Reader}, nil
+}
+
+// Compute the Sec-WebSocket-Accept value for a key.
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// Returns true if a comma separated header contains a token.
+func headerHas(h http.Header, name string, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Read the next text or binary message.  Pings are answered and pongs
+// skipped along the way.  Once the peer closes the connection the close
+// is acknowledged and a *CloseError returned.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var msg []byte
+	op := -1
+	for {
+		fin, fop, data, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch fop {
+		case PingMessage:
+			if err = c.WriteMessage(PongMessage, data); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			// 1005 stands for a close without a status and mustn't
+			// be sent so such a close gets an empty reply
+			ce := &CloseError{Code: CloseNoStatus}
+			if len(data) >= 2 {
+				ce.Code = int(binary.BigEndian.Uint16(data))
+				ce.Reason = string(data[2:])
+				c.writeClose(ce.Code, "")
+			} else {
+				c.WriteMessage(CloseMessage, nil)
+			}
+			return 0, nil, ce
+		case continuation:
+			if op < 0 {
+				return 0, nil, c.fail(CloseProtocol, "unexpected continuation frame")
+			}
+		case TextMessage, BinaryMessage:
+			if op >= 0 {
+				return 0, nil, c.fail(CloseProtocol, "expected continuation frame")
+			}
+			op = fop
+		default:
+			return 0, nil, c.fail(CloseProtocol, fmt.Sprintf("unknown opcode %d", fop))
+		}
+		if len(msg)+len(data) > MaxMessageSize {
+			return 0, nil, c.fail(CloseTooBig, "message too big")
+		}
+		msg = append(msg, data...)
+		if fin {
+			return op, msg, nil
+		}
+	}
+}
+
+// Read one frame and return whether it is the last of its message, its
+// opcode and its unmasked payload.
+func (c *Conn) readFrame() (bool, int, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := hdr[0]&0x80 != 0
+	op := int(hdr[0] & 0x0f)
+	if hdr[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocol, "reserved bits set")
+	}
+	masked := hdr[1]&0x80 != 0
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= CloseMessage && (n > 125 || !fin) {
+		return false, 0, nil, c.fail(CloseProtocol, "bad control frame")
+	}
+	if n > MaxMessageSize {
+		return false, 0, nil, c.fail(CloseTooBig, "message too big")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.br, data); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, data, nil
+}
+
+// Write a message in a single frame.
+func (c *Conn) WriteMessage(op int, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return fmt.Errorf("websocket: connection closed")
+	}
+	if op == CloseMessage {
+		c.closed = true
+	}
+	return c.writeFrame(op, data)
+}
+
+// Must be called with the write lock held.
+func (c *Conn) writeFrame(op int, data []byte) error {
+	hdr := []byte{0x80 | byte(op), 0}
+	switch n := len(data); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xffff:
+		hdr[1] = 126
+		hdr = append(hdr, 0, 0)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = append(hdr, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	}
+
+	if !c.client {
+		_, err := c.conn.Write(append(hdr, data...))
+		return err
+	}
+
+	// clients must mask every frame with a fresh random key
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	hdr[1] |= 0x80
+	frame := append(append(hdr, mask[:]...), data...)
+	body := frame[len(frame)-len(data):]
+	for i := range body {
+		body[i] ^= mask[i%4]
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Send a close frame unless one was already sent.
+func (c *Conn) writeClose(code int, reason string) error {
+	data := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(data, uint16(code))
+	return c.WriteMessage(CloseMessage, append(data, reason...))
+}
+
+// Close the connection after a protocol error and return the error.
+func (c *Conn) fail(code int, reason string) error {
+	c.writeClose(code, reason)
+	c.conn.Close()
+	return fmt.Errorf("websocket: %s", reason)
+}
+
+// Set a deadline for reads.  A zero time means no deadline.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// Close the connection, telling the peer first if possible.
+func (c *Conn) Close() error {
+	c.writeClose(CloseNormal, "")
+	return c.conn.Close()
+}